@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func (c *Client) reply(format string, args ...interface{}) {
+	c.Notify <- StringMsg{
+		str: fmt.Sprintf(format, args...),
+	}
+}
+
+func (c *Client) leave() {
+	if c.room == nil {
+		return
+	}
+	// The client may be renamed as soon as it's out of the room, so the
+	// name is taken now rather than when the room gets around to it.
+	c.room.InChan <- LeaveMsg{
+		Client: c,
+		name:   c.Name,
+		room:   c.room.Name,
+	}
+	c.room = nil
+}
+
+func handleCommand(c *Client, rooms *Rooms, line string) {
+	cmd, arg, _ := strings.Cut(line, " ")
+	switch strings.ToLower(cmd) {
+	case "/join":
+		if !nameValid(arg) {
+			c.reply("* Invalid room name.\n")
+			return
+		}
+		if c.room != nil && c.room.Name == arg {
+			c.reply("* You are already in %s\n", arg)
+			return
+		}
+		// Join before leaving, so a refused join leaves the client where
+		// it was.
+		room, err := rooms.Join(arg, c)
+		if errors.Is(err, ErrShuttingDown) {
+			c.reply("* Can't join %s: the server is shutting down.\n", arg)
+			return
+		} else if err != nil {
+			c.reply("* Can't join %s: the name %s is already in use there.\n", arg, c.Name)
+			return
+		}
+		c.leave()
+		c.room = room
+	case "/part":
+		if c.room == nil {
+			c.reply("* You are not in a room.\n")
+			return
+		}
+		name := c.room.Name
+		c.leave()
+		c.reply("* You have left %s\n", name)
+	case "/rooms":
+		list := []string{}
+		for _, info := range rooms.List() {
+			list = append(list, fmt.Sprintf("%s (%d)", info.Name, info.Members))
+		}
+		c.reply("* Rooms: %s\n", strings.Join(list, ", "))
+	case "/who":
+		if c.room == nil {
+			c.reply("* You are not in a room.\n")
+			return
+		}
+		c.room.InChan <- WhoMsg{
+			Client: c,
+		}
+	case "/msg":
+		to, text, ok := strings.Cut(arg, " ")
+		if !ok || to == "" || text == "" {
+			c.reply("* Usage: /msg <name> <message>\n")
+			return
+		}
+		if c.room == nil {
+			c.reply("* You are not in a room.\n")
+			return
+		}
+		c.room.InChan <- PrivMsg{
+			Sender: c,
+			To:     to,
+			Msg:    text,
+		}
+	case "/nick":
+		if !nameValid(arg) {
+			c.reply("* Invalid name.\n")
+			return
+		}
+		if c.room == nil {
+			c.Name = arg
+			c.reply("* You are now known as %s\n", arg)
+			return
+		}
+		result := make(chan error, 1)
+		c.room.InChan <- NickMsg{
+			Client:  c,
+			NewName: arg,
+			Result:  result,
+		}
+		if err := <-result; err != nil {
+			c.reply("* The name %s is already in use.\n", arg)
+		}
+	default:
+		c.reply("* Unknown command %s. Try /join, /part, /rooms, /who, /msg or /nick.\n", cmd)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// DEFAULT_HISTORY is how many chat lines each room replays to new joiners.
+const DEFAULT_HISTORY = 20
+
+// History is a ring buffer of a room's most recent chat lines, as sent to
+// clients (newline included).
+type History struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newHistory(size int) *History {
+	if size < 0 {
+		size = 0
+	}
+	return &History{lines: make([]string, size)}
+}
+
+func (h *History) Add(line string) {
+	if len(h.lines) == 0 {
+		return
+	}
+	h.lines[h.next] = line
+	h.next = (h.next + 1) % len(h.lines)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Lines returns the buffered lines, oldest first.
+func (h *History) Lines() []string {
+	lines := []string{}
+	if h.full {
+		lines = append(lines, h.lines[h.next:]...)
+	}
+	return append(lines, h.lines[:h.next]...)
+}
+
+// load adds every line from r, so only the last ones are kept.
+func (h *History) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		h.Add(scanner.Text() + "\n")
+	}
+	return scanner.Err()
+}
+
+// openHistory sets up the Channel's history from the registry's settings,
+// reading back and then appending to the room's log file if there is one.
+//
+// It's called from Handle when the first message arrives rather than when the
+// Channel is made, because main fills in the settings from flags after
+// newRooms has already made the default room.
+func (c *Channel) openHistory() {
+	size, dir := DEFAULT_HISTORY, ""
+	if c.rooms != nil {
+		size, dir = c.rooms.HistorySize, c.rooms.LogDir
+	}
+	c.history = newHistory(size)
+	if dir == "" {
+		return
+	}
+	// room names are checked by nameValid, so they're safe as file names
+	path := filepath.Join(dir, c.Name+".log")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Println("could not open room log", err)
+		return
+	}
+	if err := c.history.load(f); err != nil {
+		log.Println("could not read room log", err)
+	}
+	c.logFile = f
+}
+
+// replay sends the room's history to a joiner. Only the latest lines that
+// fit in the joiner's queue are sent, and the overflow policy isn't applied,
+// so a history as long as the queue can't get the joiner kicked.
+func (c *Channel) replay(client *Client) {
+	lines := c.history.Lines()
+	if free := cap(client.Notify) - len(client.Notify); len(lines) > free {
+		lines = lines[len(lines)-free:]
+	}
+	for _, line := range lines {
+		client.deliver(StringMsg{str: line}, OverflowDrop)
+	}
+}
+
+// record remembers a line said in the room.
+func (c *Channel) record(line string) {
+	c.history.Add(line)
+	if c.logFile == nil {
+		return
+	}
+	if _, err := io.WriteString(c.logFile, line); err != nil {
+		log.Println("could not write room log, giving up on it", err)
+		c.closeHistory()
+	}
+}
+
+func (c *Channel) closeHistory() {
+	if c.logFile != nil {
+		c.logFile.Close()
+		c.logFile = nil
+	}
+}
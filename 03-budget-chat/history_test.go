@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryRing(t *testing.T) {
+	cases := []struct {
+		size  int
+		added int
+		want  []string
+	}{
+		{3, 0, []string{}},
+		{3, 2, []string{"0", "1"}},
+		{3, 3, []string{"0", "1", "2"}},
+		{3, 7, []string{"4", "5", "6"}},
+		{0, 5, []string{}},
+	}
+	for _, c := range cases {
+		h := newHistory(c.size)
+		for i := 0; i < c.added; i++ {
+			h.Add(fmt.Sprint(i))
+		}
+		if got := h.Lines(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("size %d after %d: got %v, want %v", c.size, c.added, got, c.want)
+		}
+	}
+}
+
+func TestHistoryReplay(t *testing.T) {
+	rooms := newRooms()
+	rooms.HistorySize = 2
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	alice.send("one")
+	alice.send("two")
+	alice.send("three")
+	alice.send("/who") // wait for the room to get through them
+	alice.expect("Users in general")
+
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	bob.expect("[alice] two")
+	bob.expect("[alice] three")
+	bob.send("/who")
+	bob.expect("Users in general: alice,bob")
+}
+
+func TestHistoryLongerThanQueue(t *testing.T) {
+	rooms := newRooms()
+	rooms.QueueSize = 4
+	rooms.HistorySize = 10
+	rooms.Overflow = OverflowDisconnect
+	rooms.Limits.Rate = 0
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	for i := 0; i < rooms.HistorySize; i++ {
+		alice.send(fmt.Sprint("line ", i))
+	}
+	alice.send("/who")
+	alice.expect("Users in general")
+
+	// bob never reads, and a kick would panic on the nil conn
+	bob := newClient("bob", nil, rooms.QueueSize)
+	if _, err := rooms.Join(rooms.Default, bob); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for len(bob.Notify) > 0 {
+		got = append(got, (<-bob.Notify).String())
+	}
+	want := []string{"* The room contains: alice\n", "[alice] line 7\n", "[alice] line 8\n", "[alice] line 9\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHistoryLog(t *testing.T) {
+	dir := t.TempDir()
+	rooms := newRooms()
+	rooms.LogDir = dir
+	alice := connectClient(t, rooms, "alice")
+	alice.send("/join dev")
+	alice.expect("The room contains: ")
+	alice.send("remember me")
+	alice.send("/part")
+	alice.expect("You have left dev")
+	alice.conn.Close()
+
+	// a new server, as if after a restart
+	rooms = newRooms()
+	rooms.LogDir = dir
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	bob.send("/join dev")
+	bob.expect("The room contains: ")
+	bob.expect("[alice] remember me")
+}
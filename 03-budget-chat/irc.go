@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+)
+
+// IRC compatibility: enough of RFC 1459/2812 for ordinary IRC clients to
+// chat in the same rooms as everyone else. Room "general" is channel
+// "#general". Budget chat clients are only ever in one room, so joining an
+// IRC channel parts the one the client was in.
+
+// IRC_SERVER is the server name used as the prefix of numeric replies.
+const IRC_SERVER = "budgetchat"
+
+type ircMessage struct {
+	command string
+	params  []string
+}
+
+// parseIRC splits a line into its command and parameters, dropping any
+// prefix. The command is upper-cased.
+func parseIRC(line string) ircMessage {
+	var m ircMessage
+	line = strings.TrimLeft(line, " ")
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			break
+		}
+		if m.command != "" && strings.HasPrefix(line, ":") {
+			m.params = append(m.params, line[1:])
+			break
+		}
+		var word string
+		word, line, _ = strings.Cut(line, " ")
+		if m.command == "" {
+			m.command = strings.ToUpper(word)
+		} else {
+			m.params = append(m.params, word)
+		}
+	}
+	return m
+}
+
+func (m ircMessage) param(i int) string {
+	if i < len(m.params) {
+		return m.params[i]
+	}
+	return ""
+}
+
+// ircLine is a line already in IRC form, without the CRLF, for the client's
+// own connection goroutine to queue.
+type ircLine string
+
+func (l ircLine) String() string {
+	return string(l) + "\n"
+}
+
+func ircPrefix(nick string) string {
+	return fmt.Sprintf("%s!%s@%s", nick, nick, IRC_SERVER)
+}
+
+func ircNumeric(code int, nick string, params string) string {
+	return fmt.Sprintf(":%s %03d %s %s", IRC_SERVER, code, nick, params)
+}
+
+// ircFormat renders what the rooms send as IRC lines. Anything it doesn't
+// know about becomes a NOTICE.
+func ircFormat(msg NotifyMsg) string {
+	var lines []string
+	switch m := msg.(type) {
+	case ircLine:
+		lines = []string{string(m)}
+	case MsgMsg:
+		lines = []string{fmt.Sprintf(":%s PRIVMSG #%s :%s", ircPrefix(m.name), m.room, m.Msg)}
+	case PrivMsg:
+		lines = []string{fmt.Sprintf(":%s PRIVMSG %s :%s", ircPrefix(m.name), m.To, m.Msg)}
+	case JoinMsg:
+		lines = []string{fmt.Sprintf(":%s JOIN #%s", ircPrefix(m.name), m.room)}
+	case LeaveMsg:
+		lines = []string{fmt.Sprintf(":%s PART #%s", ircPrefix(m.name), m.room)}
+	case NickMsg:
+		lines = []string{fmt.Sprintf(":%s NICK :%s", ircPrefix(m.oldName), m.NewName)}
+	case RosterMsg:
+		names := m.Names
+		if m.Joined {
+			// IRC clients learn they're in from the echo of their JOIN,
+			// and expect to be in the names list
+			lines = append(lines, fmt.Sprintf(":%s JOIN #%s", ircPrefix(m.name), m.Room))
+			names = append(names, m.name)
+		}
+		lines = append(lines,
+			ircNumeric(353, m.name, fmt.Sprintf("= #%s :%s", m.Room, strings.Join(names, " "))),
+			ircNumeric(366, m.name, fmt.Sprintf("#%s :End of /NAMES list.", m.Room)))
+	case hangupMsg:
+		lines = []string{"ERROR :" + ircText(m.String())}
+	default:
+		lines = []string{fmt.Sprintf(":%s NOTICE * :%s", IRC_SERVER, ircText(msg.String()))}
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// ircText turns a budget chat notice into IRC message text.
+func ircText(s string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(s, "\n"), "* ")
+}
+
+// ircRoom returns the room name for an IRC channel name, or "" if it isn't a
+// valid one.
+func ircRoom(channel string) string {
+	if !strings.HasPrefix(channel, "#") {
+		return ""
+	}
+	name := strings.TrimPrefix(channel, "#")
+	if !nameValid(name) {
+		return ""
+	}
+	return name
+}
+
+// handleIRC serves a connection from an IRC client.
+func handleIRC(conn net.Conn, rooms *Rooms) {
+	limits := rooms.Limits
+	reader := bufio.NewReader(conn)
+	// until registration there's no Client, so replies are written directly
+	reply := func(line string) {
+		io.WriteString(conn, line+"\r\n")
+	}
+	nick, user := "", false
+	for nick == "" || !user {
+		line, tooLong, err := readLine(reader, limits.MaxLineLength)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		if tooLong {
+			continue
+		}
+		msg := parseIRC(sanitize(line))
+		switch msg.command {
+		case "":
+		case "CAP":
+			if msg.param(0) == "LS" {
+				reply(fmt.Sprintf(":%s CAP * LS :", IRC_SERVER))
+			}
+		case "PING":
+			reply(fmt.Sprintf(":%s PONG %s :%s", IRC_SERVER, IRC_SERVER, msg.param(0)))
+		case "NICK":
+			if !nameValid(msg.param(0)) {
+				reply(ircNumeric(432, "*", msg.param(0)+" :Erroneous nickname"))
+				continue
+			}
+			nick = msg.param(0)
+		case "USER":
+			user = true
+		case "QUIT":
+			reply("ERROR :Closing link")
+			conn.Close()
+			return
+		default:
+			reply(ircNumeric(451, "*", ":You have not registered"))
+		}
+	}
+	log.Println("irc client registered as", nick)
+
+	c := newClient(nick, &conn, rooms.QueueSize)
+	c.format = ircFormat
+	c.run(rooms, func() {
+		c.Notify <- ircLine(ircNumeric(1, nick, ":Welcome to Budget Chat, "+nick))
+		c.Notify <- ircLine(ircNumeric(422, nick, ":MOTD File is missing"))
+		readLines(c, reader, limits, func(line string) bool {
+			return c.handleIRCCommand(rooms, parseIRC(line))
+		})
+	})
+}
+
+func (c *Client) ircReply(code int, params string) {
+	c.Notify <- ircLine(ircNumeric(code, c.Name, params))
+}
+
+// ircPart leaves the client's room, telling it so the IRC way.
+func (c *Client) ircPart() {
+	if c.room == nil {
+		return
+	}
+	c.Notify <- ircLine(fmt.Sprintf(":%s PART #%s", ircPrefix(c.Name), c.room.Name))
+	c.leave()
+}
+
+// handleIRCCommand acts on a message from a registered IRC client. It
+// returns false if the client is done.
+func (c *Client) handleIRCCommand(rooms *Rooms, msg ircMessage) bool {
+	switch msg.command {
+	case "":
+	case "JOIN":
+		channel, _, _ := strings.Cut(msg.param(0), ",")
+		if channel == "0" {
+			c.ircPart()
+			return true
+		}
+		name := ircRoom(channel)
+		if name == "" {
+			c.ircReply(403, channel+" :No such channel")
+			return true
+		}
+		if c.room != nil && c.room.Name == name {
+			return true
+		}
+		// join before parting, so a refused join leaves the client where
+		// it was, as with /join
+		room, err := rooms.Join(name, c)
+		if errors.Is(err, ErrShuttingDown) {
+			return false
+		} else if err != nil {
+			c.ircReply(443, fmt.Sprintf("%s %s :Someone in there is already called that", c.Name, channel))
+			return true
+		}
+		c.ircPart()
+		c.room = room
+	case "PART":
+		channel, _, _ := strings.Cut(msg.param(0), ",")
+		if c.room == nil || ircRoom(channel) != c.room.Name {
+			c.ircReply(442, channel+" :You're not on that channel")
+			return true
+		}
+		c.ircPart()
+	case "PRIVMSG", "NOTICE":
+		target, text := msg.param(0), msg.param(1)
+		if text == "" {
+			c.ircReply(412, ":No text to send")
+			return true
+		}
+		if strings.HasPrefix(target, "#") {
+			if c.room == nil || ircRoom(target) != c.room.Name {
+				c.ircReply(404, target+" :Cannot send to channel")
+				return true
+			}
+			c.room.InChan <- MsgMsg{
+				Sender: c,
+				Msg:    text,
+			}
+			return true
+		}
+		if c.room == nil {
+			c.ircReply(401, target+" :No such nick/channel")
+			return true
+		}
+		c.room.InChan <- PrivMsg{
+			Sender: c,
+			To:     target,
+			Msg:    text,
+		}
+	case "NICK":
+		name := msg.param(0)
+		if !nameValid(name) {
+			c.ircReply(432, name+" :Erroneous nickname")
+			return true
+		}
+		if c.room == nil {
+			c.Notify <- ircLine(fmt.Sprintf(":%s NICK :%s", ircPrefix(c.Name), name))
+			c.Name = name
+			return true
+		}
+		result := make(chan error, 1)
+		c.room.InChan <- NickMsg{
+			Client:  c,
+			NewName: name,
+			Result:  result,
+		}
+		if err := <-result; err != nil {
+			c.ircReply(433, name+" :Nickname is already in use")
+		}
+	case "NAMES":
+		channel := msg.param(0)
+		if c.room != nil && (channel == "" || ircRoom(channel) == c.room.Name) {
+			c.room.InChan <- WhoMsg{
+				Client: c,
+			}
+			return true
+		}
+		if channel == "" {
+			channel = "*"
+		}
+		c.ircReply(366, channel+" :End of /NAMES list.")
+	case "PING":
+		c.Notify <- ircLine(fmt.Sprintf(":%s PONG %s :%s", IRC_SERVER, IRC_SERVER, msg.param(0)))
+	case "PONG", "CAP":
+	case "USER":
+		c.ircReply(462, ":You may not reregister")
+	case "QUIT":
+		c.hangup("Closing link")
+		return false
+	default:
+		c.ircReply(421, msg.command+" :Unknown command")
+	}
+	return true
+}
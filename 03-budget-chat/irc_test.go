@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseIRC(t *testing.T) {
+	cases := []struct {
+		line string
+		want ircMessage
+	}{
+		{"NICK alice", ircMessage{"NICK", []string{"alice"}}},
+		{"privmsg #general :hello there", ircMessage{"PRIVMSG", []string{"#general", "hello there"}}},
+		{":alice!a@host PRIVMSG bob ::)", ircMessage{"PRIVMSG", []string{"bob", ":)"}}},
+		{"USER alice 0 * :Alice Liddell", ircMessage{"USER", []string{"alice", "0", "*", "Alice Liddell"}}},
+		{"PING  :token ", ircMessage{"PING", []string{"token "}}},
+		{"QUIT", ircMessage{"QUIT", nil}},
+		{"", ircMessage{}},
+	}
+	for _, c := range cases {
+		if got := parseIRC(c.line); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseIRC(%q) = %#v, want %#v", c.line, got, c.want)
+		}
+	}
+}
+
+func connectIRC(t *testing.T, rooms *Rooms, nick string) *testClient {
+	server, client := net.Pipe()
+	go handleIRC(server, rooms)
+	tc := &testClient{t, client, bufio.NewScanner(client)}
+	tc.send("CAP LS 302")
+	tc.expect(":budgetchat CAP * LS :")
+	tc.send("NICK " + nick)
+	tc.send("USER " + nick + " 0 * :Real Name")
+	tc.expect(" 001 " + nick + " :Welcome")
+	tc.expect(" 422 ")
+	return tc
+}
+
+func TestIRC(t *testing.T) {
+	rooms := newRooms()
+	// bob has a lot to say
+	rooms.Limits.Rate = 0
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	bob := connectIRC(t, rooms, "bob")
+	defer bob.conn.Close()
+
+	bob.send("PING :hello")
+	bob.expect("PONG budgetchat :hello")
+
+	bob.send("JOIN #general")
+	bob.expect(":bob!bob@budgetchat JOIN #general")
+	bob.expect(":budgetchat 353 bob = #general :alice bob")
+	bob.expect(":budgetchat 366 bob #general :End of /NAMES list.")
+	alice.expect("* bob has entered the room")
+
+	bob.send("PRIVMSG #general :hi alice")
+	alice.expect("[bob] hi alice")
+	alice.send("hi bob")
+	bob.expect(":alice!alice@budgetchat PRIVMSG #general :hi bob")
+
+	bob.send("PRIVMSG alice :psst")
+	alice.expect("[bob (private)] psst")
+	alice.send("/msg bob shh")
+	bob.expect(":alice!alice@budgetchat PRIVMSG bob :shh")
+
+	bob.send("NAMES #general")
+	bob.expect(" 353 bob = #general :alice bob")
+	bob.expect(" 366 bob #general ")
+
+	bob.send("NICK alice")
+	bob.expect(" 433 bob alice :Nickname is already in use")
+	bob.send("NICK robert")
+	bob.expect(":bob!bob@budgetchat NICK :robert")
+	alice.expect("* bob is now known as robert")
+	alice.send("/nick ally")
+	bob.expect(":alice!alice@budgetchat NICK :ally")
+	alice.expect("* alice is now known as ally")
+
+	bob.send("PRIVMSG #elsewhere :hello")
+	bob.expect(" 404 robert #elsewhere ")
+	bob.send("FROB")
+	bob.expect(" 421 robert FROB ")
+
+	bob.send("JOIN #dev")
+	bob.expect(":robert!robert@budgetchat JOIN #dev")
+	bob.expect(" 353 robert = #dev :robert")
+	bob.expect(" 366 ")
+	bob.expect(":robert!robert@budgetchat PART #general")
+	alice.expect("* robert has left the room")
+
+	alice.send("/join dev")
+	alice.expect("The room contains: robert")
+	bob.expect(":ally!ally@budgetchat JOIN #dev")
+	bob.send("PART #dev")
+	bob.expect(":robert!robert@budgetchat PART #dev")
+	alice.expect("* robert has left the room")
+
+	bob.send("QUIT :bye")
+	bob.expect("ERROR :Closing link")
+	if bob.sc.Scan() {
+		t.Fatalf("expected disconnect, got %q", bob.sc.Text())
+	}
+}
+
+func TestIRCJoinRefused(t *testing.T) {
+	rooms := newRooms()
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	// someone else called bob is already in dev
+	other := connectClient(t, rooms, "bob")
+	defer other.conn.Close()
+	alice.expect("* bob has entered the room")
+	other.send("/join dev")
+	other.expect("The room contains: ")
+	alice.expect("* bob has left the room")
+	bob := connectIRC(t, rooms, "bob")
+	defer bob.conn.Close()
+	bob.send("JOIN #general")
+	bob.expect(":bob!bob@budgetchat JOIN #general")
+	bob.expect(" 353 bob = #general :alice bob")
+	bob.expect(" 366 ")
+	alice.expect("* bob has entered the room")
+
+	bob.send("JOIN #dev")
+	bob.expect(" 443 bob bob #dev :Someone in there is already called that")
+	// bob is still in general, and can still talk there
+	bob.send("PRIVMSG #general :still here")
+	alice.expect("[bob] still here")
+	bob.send("NAMES #general")
+	bob.expect(" 353 bob = #general :alice bob")
+}
+
+func TestIRCRegistration(t *testing.T) {
+	rooms := newRooms()
+	server, client := net.Pipe()
+	defer client.Close()
+	go handleIRC(server, rooms)
+	tc := &testClient{t, client, bufio.NewScanner(client)}
+	tc.send("JOIN #general")
+	tc.expect(" 451 * :You have not registered")
+	tc.send("NICK not/valid")
+	tc.expect(" 432 * not/valid ")
+	tc.send("NICK carol")
+	tc.send("USER carol 0 * :Carol")
+	tc.expect(" 001 carol ")
+	tc.expect(" 422 ")
+
+	// the IRC client is only in a room once it joins one
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	alice.send("/who")
+	alice.expect("* Users in general: alice")
+
+	rooms.Shutdown(GOODBYE)
+	tc.expect("ERROR :The server is shutting down. Goodbye!")
+}
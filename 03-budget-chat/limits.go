@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Limits bound what a client can send.
+type Limits struct {
+	// MaxLineLength is the longest line, in bytes, a client can send. Longer
+	// lines are thrown away.
+	MaxLineLength int
+	// Rate is how many lines a second a client can keep up, and Burst how
+	// many it can send at once. A Rate of 0 turns flood control off.
+	Rate  float64
+	Burst int
+	// FloodWarnings is how many lines in a row a client can have refused
+	// for flooding before it's kicked.
+	FloodWarnings int
+}
+
+var DefaultLimits = Limits{
+	MaxLineLength: 1000,
+	Rate:          5,
+	Burst:         10,
+	FloodWarnings: 3,
+}
+
+// readLine reads a line of at most max bytes, not counting the newline. A
+// longer line is read to its end and discarded, and tooLong is set. Like
+// bufio.Scanner, it returns a final line even if it has no newline, and drops
+// a \r before the newline.
+func readLine(r *bufio.Reader, max int) (line string, tooLong bool, err error) {
+	var sb strings.Builder
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// leave room for a \r, which doesn't count
+			if sb.Len()+len(chunk) > max+1 {
+				tooLong = true
+			} else {
+				sb.Write(chunk)
+			}
+			continue
+		}
+		// an unterminated last line still counts; the next call gets the EOF
+		partial := err == io.EOF && (sb.Len() > 0 || len(chunk) > 0 || tooLong)
+		if err != nil && !partial {
+			return "", false, err
+		}
+		if err == nil {
+			chunk = chunk[:len(chunk)-1]
+		}
+		if tooLong {
+			return "", true, nil
+		}
+		sb.Write(chunk)
+		line = strings.TrimSuffix(sb.String(), "\r")
+		if len(line) > max {
+			return "", true, nil
+		}
+		return line, false, nil
+	}
+}
+
+// readLines calls handle with each line the client sends, sanitized, until
+// the connection ends, handle returns false, or the client is kicked for
+// flooding.
+func readLines(c *Client, reader *bufio.Reader, limits Limits, handle func(line string) bool) {
+	bucket := newTokenBucket(limits.Rate, limits.Burst, time.Now())
+	warnings := 0
+	for {
+		line, tooLong, err := readLine(reader, limits.MaxLineLength)
+		if err != nil {
+			return
+		}
+		if !bucket.allow(time.Now()) {
+			warnings++
+			if warnings > limits.FloodWarnings {
+				log.Println("kicking", c.Name, "for flooding")
+				c.hangup(KICKED)
+				return
+			}
+			c.reply("* Slow down! That line was not sent.\n")
+			continue
+		}
+		warnings = 0
+		if tooLong {
+			c.reply("* That line was too long. The limit is %d bytes.\n", limits.MaxLineLength)
+			continue
+		}
+		line = sanitize(line)
+		if line == "" {
+			continue
+		}
+		if !handle(line) {
+			return
+		}
+	}
+}
+
+// sanitize drops everything but printable characters (including spaces)
+// from a line, along with any invalid UTF-8.
+func sanitize(line string) string {
+	return strings.Map(func(r rune) rune {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, line)
+}
+
+// tokenBucket is a client's flood control. Each line costs a token, and
+// tokens come back at rate per second up to burst.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// allow takes a token if there is one.
+func (b *tokenBucket) allow(now time.Time) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
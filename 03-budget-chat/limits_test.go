@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLine(t *testing.T) {
+	type result struct {
+		line    string
+		tooLong bool
+	}
+	cases := []struct {
+		input string
+		max   int
+		want  []result
+	}{
+		{"hello\nworld\n", 10, []result{{"hello", false}, {"world", false}}},
+		{"hello\nno newline", 10, []result{{"hello", false}, {"no newline", false}}},
+		{"exactly10!\n", 10, []result{{"exactly10!", false}}},
+		{"eleven1234!\nok\n", 10, []result{{"", true}, {"ok", false}}},
+		{strings.Repeat("x", 100) + "\nok\n", 10, []result{{"", true}, {"ok", false}}},
+		{"last", 10, []result{{"last", false}}},
+		{"\n", 10, []result{{"", false}}},
+		{"name\r\n", 10, []result{{"name", false}}},
+		{"hi there\r\nexactly10!\r\n", 10, []result{{"hi there", false}, {"exactly10!", false}}},
+		{"eleven1234!\r\n", 10, []result{{"", true}}},
+		{"no\rnewline\r", 10, []result{{"no\rnewline", false}}},
+	}
+	for _, c := range cases {
+		// a small buffer so long lines take several reads
+		r := bufio.NewReaderSize(strings.NewReader(c.input), 16)
+		got := []result{}
+		for {
+			line, tooLong, err := readLine(r, c.max)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%q: %v", c.input, err)
+			}
+			got = append(got, result{line, tooLong})
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%q: got %v, want %v", c.input, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%q: got %v, want %v", c.input, got, c.want)
+			}
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"hello world", "hello world"},
+		{"bell\a and\x1b[31m escape", "bell and[31m escape"},
+		{"tab\there\r", "tabhere"},
+		{"héllo ☃", "héllo ☃"},
+		{"bad \xff utf8", "bad  utf8"},
+		{"\x00\x01\x02", ""},
+	}
+	for _, c := range cases {
+		if got := sanitize(c.input); got != c.want {
+			t.Errorf("sanitize(%q) = %q, want %q", c.input, got, c.want)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	b := newTokenBucket(2, 3, start)
+	for i := 0; i < 3; i++ {
+		if !b.allow(start) {
+			t.Fatalf("burst line %d refused", i)
+		}
+	}
+	if b.allow(start) {
+		t.Fatal("line past the burst allowed")
+	}
+	if !b.allow(start.Add(500 * time.Millisecond)) {
+		t.Fatal("line refused after a token came back")
+	}
+	if b.allow(start.Add(500 * time.Millisecond)) {
+		t.Fatal("second line allowed with one token back")
+	}
+	// a long wait only refills up to the burst
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !b.allow(later) {
+			t.Fatalf("line %d refused after refilling", i)
+		}
+	}
+	if b.allow(later) {
+		t.Fatal("bucket refilled past its burst")
+	}
+
+	if !newTokenBucket(0, 0, start).allow(start) {
+		t.Fatal("a rate of 0 should allow everything")
+	}
+}
+
+func TestLineLimits(t *testing.T) {
+	rooms := newRooms()
+	rooms.Limits.MaxLineLength = 20
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	alice.expect("bob has entered the room")
+
+	alice.send(strings.Repeat("spam", 10))
+	alice.expect("* That line was too long. The limit is 20 bytes.")
+	alice.send("\a\x1b\x7f")
+	alice.send("hi\abob\x00")
+	bob.expect("[alice] hibob")
+}
+
+func TestFloodKick(t *testing.T) {
+	rooms := newRooms()
+	rooms.Limits.Rate = 0.001
+	rooms.Limits.Burst = 2
+	rooms.Limits.FloodWarnings = 2
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	alice.expect("bob has entered the room")
+
+	for i := 0; i < 5; i++ {
+		alice.send("flood")
+	}
+	bob.expect("[alice] flood")
+	bob.expect("[alice] flood")
+	alice.expect("Slow down")
+	alice.expect("Slow down")
+	alice.expect("kicked for flooding")
+	if alice.sc.Scan() {
+		t.Fatalf("expected disconnect, got %q", alice.sc.Text())
+	}
+	bob.expect("alice has left the room")
+}
+
+func TestShutdown(t *testing.T) {
+	rooms := newRooms()
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	alice.expect("bob has entered the room")
+	carol := connectClient(t, rooms, "carol")
+	defer carol.conn.Close()
+	alice.expect("carol has entered the room")
+	bob.expect("carol has entered the room")
+	carol.send("/join dev")
+	carol.expect("The room contains: ")
+	alice.expect("carol has left the room")
+	bob.expect("carol has left the room")
+	bob.send("/part")
+	bob.expect("You have left general")
+	alice.expect("bob has left the room")
+
+	drained := rooms.Shutdown(GOODBYE)
+	for _, tc := range []*testClient{alice, bob, carol} {
+		// alice may or may not hear bob go before the goodbye
+		tc.expect("shutting down")
+		if tc.sc.Scan() {
+			t.Fatalf("expected disconnect, got %q", tc.sc.Text())
+		}
+	}
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("clients never all disconnected")
+	}
+	if rooms.Shutdown(GOODBYE) != drained {
+		t.Error("second Shutdown should return the same channel")
+	}
+	if _, err := rooms.Join("dev", newClient("dave", nil, 1)); err != ErrShuttingDown {
+		t.Errorf("join after shutdown: got %v", err)
+	}
+	dave := newClient("dave", nil, 1)
+	handleCommand(dave, rooms, "/join dev")
+	if msg := (<-dave.Notify).String(); !strings.Contains(msg, "the server is shutting down") {
+		t.Errorf("/join after shutdown: got %q", msg)
+	}
+}
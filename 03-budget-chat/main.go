@@ -2,12 +2,22 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"z10f.com/golang/protohackers/08/isl"
 )
 
 //const TIMEOUT_SECONDS = 5
@@ -31,73 +41,229 @@ func (s StringMsg) String() string {
 	return s.str
 }
 
+// Client names can change (see NickMsg), and only the Channel the client is
+// in may touch Client.Name, so the Channel (or, for LeaveMsg, the leaving
+// client) fills in each message's name field and String uses that. The room
+// field is the Channel's name, for clients like IRC ones that need it.
+
 type MsgMsg struct {
 	Sender *Client
 	Msg    string
+	name   string
+	room   string
 }
 
 func (m MsgMsg) String() string {
-	return fmt.Sprintf("[%s] %s\n", m.Sender.Name, m.Msg)
+	return fmt.Sprintf("[%s] %s\n", m.name, m.Msg)
 }
 
 type Client struct {
-	Name   string
+	Name string
+	// Notify is the client's outbound queue. Channels only ever add to it
+	// with deliver.
 	Notify chan NotifyMsg
 	conn   *net.Conn
+	// room is the Channel the client is in, if any. Only the client's own
+	// connection goroutine touches it.
+	room *Channel
+	// done is closed once NotifyRoutine has finished writing.
+	done chan struct{}
+	kick sync.Once
+	bye  sync.Once
+	// format, if set, renders messages instead of their String method.
+	format func(NotifyMsg) string
+}
+
+func newClient(name string, conn *net.Conn, queueSize int) *Client {
+	return &Client{
+		Name:   name,
+		Notify: make(chan NotifyMsg, queueSize),
+		conn:   conn,
+		done:   make(chan struct{}),
+	}
 }
 
+var ErrNameInUse = errors.New("name already in use")
+
 type JoinMsg struct {
 	Client *Client
+	// Result, if set, gets nil once the client is in the room, or an error
+	// if it was turned away. It should be buffered.
+	Result chan error
+	name   string
+	room   string
 }
 
 func (j JoinMsg) String() string {
-	return fmt.Sprintf("* %s has entered the room\n", j.Client.Name)
+	return fmt.Sprintf("* %s has entered the room\n", j.name)
 }
 
 type LeaveMsg struct {
 	Client *Client
+	name   string
+	room   string
 }
 
 func (l LeaveMsg) String() string {
-	return fmt.Sprintf("* %s has left the room\n", l.Client.Name)
+	return fmt.Sprintf("* %s has left the room\n", l.name)
+}
+
+// NickMsg renames Client, if nobody else in the room has NewName.
+type NickMsg struct {
+	Client  *Client
+	NewName string
+	// Result works like JoinMsg's.
+	Result  chan error
+	oldName string
+}
+
+func (n NickMsg) String() string {
+	return fmt.Sprintf("* %s is now known as %s\n", n.oldName, n.NewName)
+}
+
+// PrivMsg is a message for just the user in the room called To.
+type PrivMsg struct {
+	Sender *Client
+	To     string
+	Msg    string
+	name   string
+}
+
+func (p PrivMsg) String() string {
+	return fmt.Sprintf("[%s (private)] %s\n", p.name, p.Msg)
+}
+
+// RosterMsg lists who's in a room, either for a client that has just joined
+// (not counting it) or in answer to a WhoMsg.
+type RosterMsg struct {
+	Room   string
+	Names  []string
+	Joined bool
+	// name is the recipient's.
+	name string
+}
+
+func (r RosterMsg) String() string {
+	if r.Joined {
+		return fmt.Sprintf("* The room contains: %s\n", strings.Join(r.Names, ","))
+	}
+	return fmt.Sprintf("* Users in %s: %s\n", r.Room, strings.Join(r.Names, ","))
+}
+
+// WhoMsg asks the Channel to tell Client who's in the room.
+type WhoMsg struct {
+	Client *Client
+}
+
+// ShutdownMsg has a Channel hang up on everyone in it with Goodbye and stop
+// once they've all left. See Rooms.Shutdown.
+type ShutdownMsg struct {
+	Goodbye string
+}
+
+// hangupMsg is written like any other message, and then NotifyRoutine closes
+// the connection.
+type hangupMsg struct {
+	str string
 }
 
-type ShutdownMsg struct{}
+func (h hangupMsg) String() string {
+	return h.str
+}
 
 type Channel struct {
+	Name   string
 	InChan chan InMsg
 	users  []*Client
+	// rooms is the registry the Channel belongs to, if any.
+	rooms *Rooms
+	// history is nil until openHistory.
+	history *History
+	logFile *os.File
+	// closing is set once the Channel has seen a ShutdownMsg.
+	closing bool
+}
+
+// send queues msg for client using the registry's overflow policy, or
+// OverflowDisconnect for a Channel without one.
+func (c *Channel) send(client *Client, msg NotifyMsg) {
+	policy := OverflowDisconnect
+	if c.rooms != nil {
+		policy = c.rooms.Overflow
+	}
+	client.deliver(msg, policy)
+}
+
+func (c *Channel) findUser(name string) *Client {
+	for _, x := range c.users {
+		if x.Name == name {
+			return x
+		}
+	}
+	return nil
 }
 
 func (c *Channel) Handle() {
+	defer c.closeHistory()
 	for msg := range c.InChan {
+		if c.history == nil {
+			c.openHistory()
+		}
 		switch v := msg.(type) {
 		case JoinMsg:
 			jmsg := msg.(JoinMsg)
+			var err error
+			if c.closing {
+				err = ErrShuttingDown
+			} else if c.findUser(jmsg.Client.Name) != nil {
+				log.Println("rejecting duplicate name", jmsg.Client.Name)
+				err = fmt.Errorf("%w: %s", ErrNameInUse, jmsg.Client.Name)
+			}
+			if err != nil {
+				if jmsg.Result != nil {
+					jmsg.Result <- err
+				}
+				// the join was counted, so it has to be uncounted
+				if c.rooms != nil && c.rooms.left(c) {
+					return
+				}
+				continue
+			}
+			jmsg.name = jmsg.Client.Name
+			jmsg.room = c.Name
 			currentClients := []string{}
 			for _, i := range c.users {
-				i.Notify <- jmsg
+				c.send(i, jmsg)
 				currentClients = append(currentClients, i.Name)
 			}
 			client := jmsg.Client
 
-			client.Notify <- StringMsg{
-				str: fmt.Sprintf("* The room contains: %s\n",
-					strings.Join(currentClients, ",")),
-			}
+			c.send(client, RosterMsg{
+				Room:   c.Name,
+				Names:  currentClients,
+				Joined: true,
+				name:   client.Name,
+			})
+			c.replay(client)
 
 			c.users = append(c.users, client)
+			if jmsg.Result != nil {
+				jmsg.Result <- nil
+			}
 		case MsgMsg:
 			mmsg := msg.(MsgMsg)
+			mmsg.name = mmsg.Sender.Name
+			mmsg.room = c.Name
+			c.record(mmsg.String())
 			for _, i := range c.users {
 				if i == mmsg.Sender {
 					continue
 				}
-				i.Notify <- mmsg
+				c.send(i, mmsg)
 			}
 		case LeaveMsg:
 			lmsg := msg.(LeaveMsg)
-			log.Println("processing leave of", lmsg.Client.Name)
+			log.Println("processing leave of", lmsg.name)
 			found := false
 			i := 0
 			for _, x := range c.users {
@@ -110,24 +276,62 @@ func (c *Channel) Handle() {
 				}
 			}
 			for j := i; j < len(c.users); j++ {
-				c.users[i] = nil
+				c.users[j] = nil
 			}
 			c.users = c.users[:i]
 			if found {
-				close(lmsg.Client.Notify)
-				(*lmsg.Client.conn).Close()
 				log.Println("Notifying result")
 				for _, x := range c.users {
 					log.Println("  Notifying", x.Name)
-					x.Notify <- lmsg
+					c.send(x, lmsg)
 				}
 			}
+			if c.rooms != nil && c.rooms.left(c) {
+				log.Println("room", c.Name, "is empty, closing it")
+				return
+			}
+		case NickMsg:
+			if other := c.findUser(v.NewName); other != nil && other != v.Client {
+				v.Result <- fmt.Errorf("%w: %s", ErrNameInUse, v.NewName)
+				continue
+			}
+			v.oldName = v.Client.Name
+			v.Client.Name = v.NewName
+			for _, x := range c.users {
+				c.send(x, v)
+			}
+			v.Result <- nil
+		case PrivMsg:
+			target := c.findUser(v.To)
+			if target == nil {
+				c.send(v.Sender, StringMsg{
+					str: fmt.Sprintf("* No one called %s in %s.\n", v.To, c.Name),
+				})
+				continue
+			}
+			v.name = v.Sender.Name
+			c.send(target, v)
+		case WhoMsg:
+			names := []string{}
+			for _, x := range c.users {
+				names = append(names, x.Name)
+			}
+			c.send(v.Client, RosterMsg{
+				Room:  c.Name,
+				Names: names,
+				name:  v.Client.Name,
+			})
 		case ShutdownMsg:
+			// each client's handleConnection cleans up the rest once its
+			// connection is closed, and a registry's room keeps going
+			// until their leaves have come in
+			c.closing = true
 			for _, i := range c.users {
-				close(i.Notify)
-				(*i.conn).Close()
+				i.hangup(v.Goodbye)
+			}
+			if c.rooms == nil || c.rooms.closeIfEmpty(c) {
+				return
 			}
-			return
 		default:
 			log.Fatal("Unknown message type", v)
 		}
@@ -139,31 +343,46 @@ func nameValid(name string) bool {
 	return nameRe.MatchString(name)
 }
 
-func (c *Client) NotifyRoutine(ch *Channel) {
+func (c *Client) NotifyRoutine() {
 	for msg := range c.Notify {
-		log.Println("writing out msg string", msg.String())
-		_, err := io.WriteString(*c.conn, msg.String())
+		text := msg.String()
+		if c.format != nil {
+			text = c.format(msg)
+		}
+		log.Println("writing out msg string", text)
+		_, err := io.WriteString(*c.conn, text)
 		if err != nil {
 			log.Println("error writing msg to client", err)
-			ch.InChan <- LeaveMsg{
-				Client: c,
-			}
-			return
+			// This ends handleConnection's read loop, which takes the
+			// client out of its room.
+			(*c.conn).Close()
+			break
+		}
+		if _, ok := msg.(hangupMsg); ok {
+			(*c.conn).Close()
+			break
 		}
 	}
+	// Keep draining so nothing ever blocks on us.
+	for range c.Notify {
+	}
+	close(c.done)
 }
 
 const GREETING = "Welcome to Budget Chat. Please enter your name.\n"
+const GOODBYE = "* The server is shutting down. Goodbye!\n"
+const KICKED = "* You have been kicked for flooding.\n"
 
-func handleConnection(conn net.Conn, channel *Channel) {
+func handleConnection(conn net.Conn, rooms *Rooms) {
+	limits := rooms.Limits
 	io.WriteString(conn, GREETING)
-	scanner := bufio.NewScanner(conn)
-	if !scanner.Scan() {
+	reader := bufio.NewReader(conn)
+	name, _, err := readLine(reader, limits.MaxLineLength)
+	if err != nil {
 		log.Println("Failed to read name")
 		conn.Close()
 		return
 	}
-	name := scanner.Text()
 	if !nameValid(name) {
 		io.WriteString(conn, "Invalid name.")
 		log.Println("Got an invalid name.")
@@ -172,28 +391,57 @@ func handleConnection(conn net.Conn, channel *Channel) {
 	}
 	log.Println("read name", name)
 
-	notify := make(chan NotifyMsg)
-	c := &Client{
-		Name:   name,
-		Notify: notify,
-		conn:   &conn,
-	}
-	go c.NotifyRoutine(channel)
-	joinMsg := JoinMsg{
-		Client: c,
-	}
-	channel.InChan <- joinMsg
-
-	for scanner.Scan() {
-		channel.InChan <- MsgMsg{
-			Sender: c,
-			Msg:    scanner.Text(),
+	c := newClient(name, &conn, rooms.QueueSize)
+	c.run(rooms, func() {
+		room, err := rooms.Join(rooms.Default, c)
+		if errors.Is(err, ErrShuttingDown) {
+			c.reply(GOODBYE)
+			return
+		} else if err != nil {
+			c.reply("* Sorry, the name %s is already in use.\n", name)
+			return
 		}
-	}
+		c.room = room
+
+		readLines(c, reader, limits, func(line string) bool {
+			if strings.HasPrefix(line, "/") {
+				handleCommand(c, rooms, line)
+				return true
+			}
+			if c.room == nil {
+				c.reply("* You are not in a room. Use /join to join one.\n")
+				return true
+			}
+			c.room.InChan <- MsgMsg{
+				Sender: c,
+				Msg:    line,
+			}
+			return true
+		})
+	})
+}
 
-	channel.InChan <- LeaveMsg{
-		Client: c,
+// run starts the client's NotifyRoutine and registers it, calls session,
+// and then takes the client out of its room and closes its connection once
+// everything queued for it has been written.
+func (c *Client) run(rooms *Rooms, session func()) {
+	go c.NotifyRoutine()
+	registered := false
+	defer func() {
+		c.leave()
+		if registered {
+			rooms.disconnected(c)
+		}
+		close(c.Notify)
+		<-c.done
+		(*c.conn).Close()
+	}()
+	if err := rooms.connected(c); err != nil {
+		c.hangup(GOODBYE)
+		return
 	}
+	registered = true
+	session()
 }
 
 func newChannel() *Channel {
@@ -203,21 +451,86 @@ func newChannel() *Channel {
 	}
 }
 
+// SHUTDOWN_TIMEOUT is how long main waits for clients to go after saying
+// goodbye.
+const SHUTDOWN_TIMEOUT = 5 * time.Second
+
+var useISL = flag.Bool("isl", false, "serve over the Insecure Sockets Layer instead of plain TCP")
+
+// serve accepts connections from l until stopping is closed.
+func serve(l net.Listener, stopping <-chan struct{}, rooms *Rooms, handle func(net.Conn, *Rooms)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-stopping:
+				return
+			default:
+				log.Println("Error accepting", err)
+				continue
+			}
+		}
+		go handle(conn, rooms)
+	}
+}
+
 func main() {
-	channel := newChannel()
-	go channel.Handle()
+	rooms := newRooms()
+	flag.IntVar(&rooms.QueueSize, "queue", DEFAULT_QUEUE_SIZE, "messages to hold for each client before applying -overflow")
+	flag.Var(&rooms.Overflow, "overflow", "what to do when a client's queue is full: disconnect or drop")
+	flag.IntVar(&rooms.HistorySize, "history", DEFAULT_HISTORY, "chat lines to replay to new joiners")
+	flag.StringVar(&rooms.LogDir, "log", "", "directory to keep room logs in, so history survives restarts")
+	flag.IntVar(&rooms.Limits.MaxLineLength, "maxline", DefaultLimits.MaxLineLength, "longest line a client can send, in bytes")
+	flag.Float64Var(&rooms.Limits.Rate, "rate", DefaultLimits.Rate, "lines per second a client can keep up, or 0 for no flood control")
+	flag.IntVar(&rooms.Limits.Burst, "burst", DefaultLimits.Burst, "lines a client can send at once")
+	flag.IntVar(&rooms.Limits.FloodWarnings, "warnings", DefaultLimits.FloodWarnings, "flood warnings a client gets before being kicked")
+	wsAddress := flag.String("ws", "", "address to serve the chat over WebSocket (and a page to use it) on, e.g. :8080")
+	ircAddress := flag.String("irc", "", "address to speak IRC on, e.g. :6667")
+	flag.Parse()
 
-	l, err := net.Listen("tcp", ":1337")
+	l, err := isl.ListenIf(*useISL, "tcp", ":1337")
 	if err != nil {
 		log.Fatal("could not listen", err)
 	}
-	defer l.Close()
-	fmt.Println("Listening.")
-	for {
-		conn, err := l.Accept()
+	var web *http.Server
+	if *wsAddress != "" {
+		web = &http.Server{Addr: *wsAddress, Handler: wsHandler(rooms)}
+		go func() {
+			if err := web.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal("could not serve websockets", err)
+			}
+		}()
+	}
+	stopping := make(chan struct{})
+	var irc net.Listener
+	if *ircAddress != "" {
+		irc, err = net.Listen("tcp", *ircAddress)
 		if err != nil {
-			log.Println("Error accepting", err)
+			log.Fatal("could not listen for IRC", err)
+		}
+		go serve(irc, stopping, rooms, handleIRC)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Println("shutting down")
+		close(stopping)
+		l.Close()
+		if irc != nil {
+			irc.Close()
 		}
-		go handleConnection(conn, channel)
+		if web != nil {
+			// this leaves the WebSockets, which Shutdown below deals with
+			web.Close()
+		}
+	}()
+	fmt.Println("Listening.")
+	serve(l, stopping, rooms, handleConnection)
+
+	select {
+	case <-rooms.Shutdown(GOODBYE):
+	case <-time.After(SHUTDOWN_TIMEOUT):
+		log.Println("gave up waiting for clients to disconnect")
 	}
 }
@@ -9,15 +9,16 @@ import (
 	"net"
 	"strings"
 	"testing"
+
+	"z10f.com/golang/protohackers/08/isl"
 )
 
 func TestConnectMsg(t *testing.T) {
-	channel := newChannel()
-	go channel.Handle()
+	rooms := newRooms()
 
 	server1, client1 := net.Pipe()
 	defer client1.Close()
-	go handleConnection(server1, channel)
+	go handleConnection(server1, rooms)
 	sc := bufio.NewScanner(client1)
 	if !sc.Scan() {
 		t.Fatal("could not scan")
@@ -30,7 +31,7 @@ func TestConnectMsg(t *testing.T) {
 	log.Println(sc.Text())
 
 	server2, client2 := net.Pipe()
-	go handleConnection(server2, channel)
+	go handleConnection(server2, rooms)
 	sc2 := bufio.NewScanner(client2)
 	if !sc2.Scan() {
 		t.Fatal("could not scan")
@@ -78,6 +79,34 @@ func TestConnectMsg(t *testing.T) {
 	}
 }
 
+func TestISL(t *testing.T) {
+	rooms := newRooms()
+	l, err := isl.ListenIf(true, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopping := make(chan struct{})
+	defer l.Close()
+	defer close(stopping)
+	go serve(l, stopping, rooms, handleConnection)
+
+	spec, err := isl.ParseCipherSpec("xor(0x7b),addpos,reversebits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := isl.Dial("tcp", l.Addr().String(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tc := &testClient{t, conn, bufio.NewScanner(conn)}
+	tc.expect("Welcome")
+	tc.send("alice")
+	tc.expect("The room contains")
+	tc.send("/rooms")
+	tc.expect("* Rooms: general (1)")
+}
+
 //func TestTimeout(t *testing.T) {
 //	server, client := net.Pipe()
 //	go func() {
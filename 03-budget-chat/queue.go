@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// DEFAULT_QUEUE_SIZE is how many messages can wait for a client before its
+// Channel applies the overflow policy.
+const DEFAULT_QUEUE_SIZE = 64
+
+// OverflowPolicy says what a Channel does with a message for a client whose
+// queue is full. A Channel never waits on a client, so one that isn't reading
+// can't hold up the rest of the room.
+type OverflowPolicy int
+
+const (
+	// OverflowDisconnect closes the client's connection, which takes it out
+	// of the room.
+	OverflowDisconnect OverflowPolicy = iota
+	// OverflowDrop throws the message away, so the client misses it.
+	OverflowDrop
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDisconnect:
+		return "disconnect"
+	case OverflowDrop:
+		return "drop"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "disconnect":
+		return OverflowDisconnect, nil
+	case "drop":
+		return OverflowDrop, nil
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q", s)
+}
+
+// Set and String let an OverflowPolicy be used with flag.Var.
+func (p *OverflowPolicy) Set(s string) error {
+	v, err := ParseOverflowPolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
+// deliver queues msg for the client without blocking, applying policy if
+// the queue is full.
+func (c *Client) deliver(msg NotifyMsg, policy OverflowPolicy) {
+	select {
+	case c.Notify <- msg:
+		return
+	default:
+	}
+	switch policy {
+	case OverflowDrop:
+		log.Println("queue full, dropping message for", c.Name)
+	case OverflowDisconnect:
+		c.kick.Do(func() {
+			log.Println("queue full, disconnecting", c.Name)
+			// NotifyRoutine and handleConnection clean up from here.
+			(*c.conn).Close()
+		})
+	}
+}
+
+// hangup sends goodbye as the client's last message and then disconnects it.
+// Only the first call does anything.
+func (c *Client) hangup(goodbye string) {
+	c.bye.Do(func() {
+		c.deliver(hangupMsg{str: goodbye}, OverflowDisconnect)
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// chatPastLurker has alice and bob chat in a room with a client that never
+// reads, and reports whether bob saw the lurker leave.
+func chatPastLurker(t *testing.T, policy OverflowPolicy) bool {
+	rooms := newRooms()
+	rooms.QueueSize = 4
+	rooms.Overflow = policy
+	rooms.Limits.Rate = 0
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	alice.expect("bob has entered the room")
+	lurker := connectClient(t, rooms, "lurker")
+	defer lurker.conn.Close()
+	alice.expect("lurker has entered the room")
+	bob.expect("lurker has entered the room")
+
+	left := false
+	for i := 0; i < 3*rooms.QueueSize; i++ {
+		msg := fmt.Sprintf("message %d", i)
+		alice.send(msg)
+		line := bob.expect("")
+		if strings.Contains(line, "lurker has left the room") {
+			left = true
+			line = bob.expect("")
+		}
+		if line != "[alice] "+msg {
+			t.Fatalf("expected %q, got %q", msg, line)
+		}
+	}
+	return left
+}
+
+func TestSlowClientDisconnected(t *testing.T) {
+	if !chatPastLurker(t, OverflowDisconnect) {
+		t.Error("client that never reads was not disconnected")
+	}
+}
+
+func TestSlowClientDropped(t *testing.T) {
+	if chatPastLurker(t, OverflowDrop) {
+		t.Error("client that never reads was disconnected")
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, p := range []OverflowPolicy{OverflowDisconnect, OverflowDrop} {
+		got, err := ParseOverflowPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseOverflowPolicy(%q) = %v, %v", p.String(), got, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("ignore"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
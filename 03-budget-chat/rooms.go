@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+const DEFAULT_ROOM = "general"
+
+type room struct {
+	channel *Channel
+	// members counts joins the channel hasn't yet processed a leave for.
+	// It's tracked here rather than in the Channel so that deciding to
+	// delete an empty room and handing the room to a new joiner can't race.
+	members int
+}
+
+// Rooms hands out a Channel per room name, starting each one's Handle
+// goroutine on first use and stopping it once the last member leaves.
+type Rooms struct {
+	Default string
+	// QueueSize and Overflow set up each client's outbound queue. See
+	// deliver.
+	QueueSize int
+	Overflow  OverflowPolicy
+	// HistorySize and LogDir are used by each room's openHistory. A
+	// joiner only gets as much history as fits in its queue; see replay.
+	HistorySize int
+	LogDir      string
+	// Limits applies to every client.
+	Limits Limits
+	lock   sync.Mutex
+	rooms  map[string]*room
+	// clients is everyone connected, so Shutdown can say goodbye to
+	// clients that aren't in a room.
+	clients map[*Client]bool
+	closed  bool
+	// drained is made by Shutdown and closed once clients is empty.
+	drained chan struct{}
+}
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+func newRooms() *Rooms {
+	r := &Rooms{
+		Default:     DEFAULT_ROOM,
+		QueueSize:   DEFAULT_QUEUE_SIZE,
+		HistorySize: DEFAULT_HISTORY,
+		Limits:      DefaultLimits,
+		rooms:       make(map[string]*room),
+		clients:     make(map[*Client]bool),
+	}
+	// the default room always exists, even when it's empty
+	r.lock.Lock()
+	r.get(r.Default)
+	r.lock.Unlock()
+	return r
+}
+
+// must be called with r.lock
+func (r *Rooms) get(name string) *room {
+	rm, ok := r.rooms[name]
+	if !ok {
+		ch := newChannel()
+		ch.Name = name
+		ch.rooms = r
+		go ch.Handle()
+		rm = &room{channel: ch}
+		r.rooms[name] = rm
+	}
+	return rm
+}
+
+// Join puts client in the named room, creating it if needed, and returns the
+// room's Channel. It fails if someone in the room already has the client's
+// name.
+func (r *Rooms) Join(name string, client *Client) (*Channel, error) {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return nil, ErrShuttingDown
+	}
+	rm := r.get(name)
+	rm.members++
+	r.lock.Unlock()
+	result := make(chan error, 1)
+	rm.channel.InChan <- JoinMsg{
+		Client: client,
+		Result: result,
+	}
+	if err := <-result; err != nil {
+		return nil, err
+	}
+	return rm.channel, nil
+}
+
+// left is called from a Channel's Handle once it has processed a leave. It
+// reports whether the room was empty and has been removed, in which case
+// Handle should return.
+func (r *Rooms) left(ch *Channel) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.rooms[ch.Name].members--
+	return r.removeIfEmpty(ch)
+}
+
+// closeIfEmpty is left without the leave, for a Channel that has just seen
+// a ShutdownMsg.
+func (r *Rooms) closeIfEmpty(ch *Channel) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.removeIfEmpty(ch)
+}
+
+// must be called with r.lock. Once Shutdown has started, rooms stay put until
+// they've seen their ShutdownMsg, so Shutdown can't send to one that's gone.
+func (r *Rooms) removeIfEmpty(ch *Channel) bool {
+	rm := r.rooms[ch.Name]
+	if rm.members != 0 {
+		return false
+	}
+	if r.closed && !ch.closing {
+		return false
+	}
+	if !r.closed && ch.Name == r.Default {
+		return false
+	}
+	delete(r.rooms, ch.Name)
+	return true
+}
+
+// connected registers a newly named client. It fails once Shutdown has been
+// called.
+func (r *Rooms) connected(c *Client) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return ErrShuttingDown
+	}
+	r.clients[c] = true
+	return nil
+}
+
+// disconnected must be called before the client's Notify is closed.
+func (r *Rooms) disconnected(c *Client) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.clients, c)
+	if r.closed && len(r.clients) == 0 {
+		close(r.drained)
+	}
+}
+
+// Shutdown turns away new clients and joins, has every room say goodbye to
+// its members and close, and hangs up on anyone who wasn't in a room. The
+// returned channel is closed once every client has disconnected.
+func (r *Rooms) Shutdown(goodbye string) <-chan struct{} {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return r.drained
+	}
+	r.closed = true
+	r.drained = make(chan struct{})
+	if len(r.clients) == 0 {
+		close(r.drained)
+	}
+	channels := []*Channel{}
+	for _, rm := range r.rooms {
+		channels = append(channels, rm.channel)
+	}
+	r.lock.Unlock()
+
+	// not under the lock, since rooms need it to process leaves
+	for _, ch := range channels {
+		ch.InChan <- ShutdownMsg{Goodbye: goodbye}
+	}
+
+	r.lock.Lock()
+	for c := range r.clients {
+		c.hangup(goodbye)
+	}
+	r.lock.Unlock()
+	return r.drained
+}
+
+type RoomInfo struct {
+	Name    string
+	Members int
+}
+
+func (r *Rooms) List() []RoomInfo {
+	r.lock.Lock()
+	infos := []RoomInfo{}
+	for name, rm := range r.rooms {
+		infos = append(infos, RoomInfo{name, rm.members})
+	}
+	r.lock.Unlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
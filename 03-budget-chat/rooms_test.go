@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	sc   *bufio.Scanner
+}
+
+func connectClient(t *testing.T, rooms *Rooms, name string) *testClient {
+	server, client := net.Pipe()
+	go handleConnection(server, rooms)
+	tc := &testClient{t, client, bufio.NewScanner(client)}
+	tc.expect("Welcome")
+	tc.send(name)
+	tc.expect("The room contains")
+	return tc
+}
+
+func (tc *testClient) send(line string) {
+	io.WriteString(tc.conn, line+"\n")
+}
+
+func (tc *testClient) expect(substr string) string {
+	tc.t.Helper()
+	if !tc.sc.Scan() {
+		tc.t.Fatalf("could not scan waiting for %q: %v", substr, tc.sc.Err())
+	}
+	if !strings.Contains(tc.sc.Text(), substr) {
+		tc.t.Fatalf("expected %q, got %q", substr, tc.sc.Text())
+	}
+	return tc.sc.Text()
+}
+
+func TestRooms(t *testing.T) {
+	rooms := newRooms()
+	// bob polls /rooms below
+	rooms.Limits.Rate = 0
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	alice.expect("bob has entered the room")
+
+	alice.send("/join dev")
+	alice.expect("The room contains: ")
+	bob.expect("alice has left the room")
+
+	bob.send("/rooms")
+	bob.expect("* Rooms: dev (1), general (1)")
+
+	// only people in general hear this
+	bob.send("nobody in dev sees this")
+
+	bob.send("/join dev")
+	bob.expect("The room contains: alice")
+	alice.expect("bob has entered the room")
+
+	bob.send("/who")
+	bob.expect("* Users in dev: alice,bob")
+
+	bob.send("hello dev")
+	alice.expect("[bob] hello dev")
+
+	alice.send("/part")
+	alice.expect("You have left dev")
+	bob.expect("alice has left the room")
+
+	alice.send("hello?")
+	alice.expect("You are not in a room")
+
+	bob.send("/part")
+	bob.expect("You have left dev")
+	// the room is closed asynchronously, so give it a moment
+	for i := 0; ; i++ {
+		bob.send("/rooms")
+		if bob.expect("* Rooms:") == "* Rooms: general (0)" {
+			break
+		} else if i == 100 {
+			t.Fatal("empty room was never removed")
+		}
+	}
+}
+
+func TestBadCommands(t *testing.T) {
+	rooms := newRooms()
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	alice.send("/join no spaces allowed")
+	alice.expect("Invalid room name")
+	alice.send("/join general")
+	alice.expect("already in general")
+	alice.send("/frobnicate")
+	alice.expect("Unknown command /frobnicate")
+}
+
+func TestDuplicateNames(t *testing.T) {
+	rooms := newRooms()
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+
+	server, client := net.Pipe()
+	go handleConnection(server, rooms)
+	dup := &testClient{t, client, bufio.NewScanner(client)}
+	defer dup.conn.Close()
+	dup.expect("Welcome")
+	dup.send("alice")
+	dup.expect("name alice is already in use")
+	if dup.sc.Scan() {
+		t.Fatalf("expected disconnect, got %q", dup.sc.Text())
+	}
+
+	// alice never heard about the impostor
+	alice.send("/who")
+	alice.expect("* Users in general: alice")
+
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	alice.expect("bob has entered the room")
+	alice.send("/join dev")
+	alice.expect("The room contains: ")
+	bob.expect("alice has left the room")
+	bob.send("/nick alice")
+	bob.expect("bob is now known as alice")
+	bob.send("/join dev")
+	bob.expect("Can't join dev: the name alice is already in use there")
+	// the refused join left bob in general
+	bob.send("/who")
+	bob.expect("* Users in general: alice")
+}
+
+func TestPrivateMessages(t *testing.T) {
+	rooms := newRooms()
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	alice.expect("bob has entered the room")
+	carol := connectClient(t, rooms, "carol")
+	defer carol.conn.Close()
+	alice.expect("carol has entered the room")
+	bob.expect("carol has entered the room")
+
+	alice.send("/msg bob psst")
+	bob.expect("[alice (private)] psst")
+	// carol only hears the public message after it
+	alice.send("hi all")
+	carol.expect("[alice] hi all")
+	bob.expect("[alice] hi all")
+
+	alice.send("/msg dave hello")
+	alice.expect("* No one called dave in general.")
+	alice.send("/msg bob")
+	alice.expect("Usage: /msg")
+}
+
+func TestNick(t *testing.T) {
+	rooms := newRooms()
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	bob := connectClient(t, rooms, "bob")
+	defer bob.conn.Close()
+	alice.expect("bob has entered the room")
+
+	alice.send("/nick bob")
+	alice.expect("The name bob is already in use")
+	alice.send("/nick not valid")
+	alice.expect("Invalid name")
+
+	alice.send("/nick ally")
+	alice.expect("alice is now known as ally")
+	bob.expect("alice is now known as ally")
+	alice.send("hello")
+	bob.expect("[ally] hello")
+	bob.send("/msg ally hi")
+	alice.expect("[bob (private)] hi")
+	alice.send("/part")
+	alice.expect("You have left general")
+	bob.expect("ally has left the room")
+
+	// outside a room there's nobody to clash with
+	alice.send("/nick bob")
+	alice.expect("You are now known as bob")
+	alice.send("/join dev")
+	alice.expect("The room contains: ")
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A WebSocket gateway (RFC 6455), so browsers can chat too. Each WebSocket
+// becomes a wsConn, which looks enough like a TCP connection to go through
+// handleConnection: every text message it receives reads as a line, and
+// every Write, which is one chat line from NotifyRoutine, goes out as a text
+// frame.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// close codes
+const (
+	wsNormalClosure   = 1000
+	wsProtocolError   = 1002
+	wsMessageTooLarge = 1009
+)
+
+// MAX_WS_MESSAGE bounds a whole message, fragments and all, so a browser
+// can't make us buffer without limit. Lines longer than Limits.MaxLineLength
+// are still turned away later, like they are over TCP.
+const MAX_WS_MESSAGE = 64 * 1024
+
+var (
+	ErrNotWebSocket    = errors.New("not a websocket handshake")
+	ErrWSProtocol      = errors.New("websocket protocol error")
+	ErrWSFrameTooLarge = errors.New("websocket message too large")
+)
+
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHas reports whether a comma-separated header has token, ignoring
+// case.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgrade does the server side of the opening handshake and takes over the
+// connection.
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") ||
+		key == "" {
+		http.Error(w, "expected a websocket handshake", http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrNotWebSocket
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't upgrade this connection", http.StatusInternalServerError)
+		return nil, ErrNotWebSocket
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", wsAcceptKey(key))
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{Conn: conn, r: rw.Reader}, nil
+}
+
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	masked  bool
+	payload []byte
+}
+
+// readFrame reads one frame, unmasking its payload.
+func readFrame(r io.Reader, maxPayload int) (wsFrame, error) {
+	var f wsFrame
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return f, err
+	}
+	f.fin = header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return f, fmt.Errorf("%w: reserved bits set", ErrWSProtocol)
+	}
+	f.opcode = header[0] & 0x0f
+	f.masked = header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return f, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return f, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if f.opcode >= wsClose && (!f.fin || length > 125) {
+		return f, fmt.Errorf("%w: bad control frame", ErrWSProtocol)
+	}
+	if length > uint64(maxPayload) {
+		return f, fmt.Errorf("%w: %d bytes", ErrWSFrameTooLarge, length)
+	}
+	var mask [4]byte
+	if f.masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	if f.masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
+
+// writeFrame writes a single, final frame. Servers don't mask; pass a mask
+// to write a client's frame.
+func writeFrame(w io.Writer, opcode byte, payload []byte, mask []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+	if mask != nil {
+		header[1] |= 0x80
+		header = append(header, mask...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// wsConn is the server's end of a WebSocket.
+type wsConn struct {
+	net.Conn
+	r *bufio.Reader
+	// pending is what's left of the last message read, newline included.
+	pending []byte
+	// writeLock keeps Read's pongs and closes from interleaving with Write.
+	writeLock sync.Mutex
+	closeOnce sync.Once
+}
+
+// Read returns text and binary messages as newline-terminated lines, answering
+// pings and closes along the way.
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = append(msg, '\n')
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		f, err := readFrame(c.r, MAX_WS_MESSAGE-len(msg))
+		if errors.Is(err, ErrWSFrameTooLarge) {
+			c.closeWith(wsMessageTooLarge)
+			return nil, err
+		} else if err != nil {
+			if errors.Is(err, ErrWSProtocol) {
+				c.closeWith(wsProtocolError)
+			}
+			return nil, err
+		}
+		if !f.masked {
+			c.closeWith(wsProtocolError)
+			return nil, fmt.Errorf("%w: unmasked client frame", ErrWSProtocol)
+		}
+		switch f.opcode {
+		case wsPing:
+			c.writeLock.Lock()
+			err := writeFrame(c.Conn, wsPong, f.payload, nil)
+			c.writeLock.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.closeWith(wsNormalClosure)
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if started {
+				c.closeWith(wsProtocolError)
+				return nil, fmt.Errorf("%w: new message inside a fragmented one", ErrWSProtocol)
+			}
+			started = true
+		case wsContinuation:
+			if !started {
+				c.closeWith(wsProtocolError)
+				return nil, fmt.Errorf("%w: continuation with nothing to continue", ErrWSProtocol)
+			}
+		default:
+			c.closeWith(wsProtocolError)
+			return nil, fmt.Errorf("%w: unknown opcode %d", ErrWSProtocol, f.opcode)
+		}
+		msg = append(msg, f.payload...)
+		if f.fin {
+			return msg, nil
+		}
+	}
+}
+
+// Write sends p as one text frame, without its trailing newline.
+func (c *wsConn) Write(p []byte) (int, error) {
+	payload := p
+	if len(payload) > 0 && payload[len(payload)-1] == '\n' {
+		payload = payload[:len(payload)-1]
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err := writeFrame(c.Conn, wsText, payload, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// closeWith sends a close frame, at most once.
+func (c *wsConn) closeWith(code uint16) {
+	c.closeOnce.Do(func() {
+		c.writeLock.Lock()
+		defer c.writeLock.Unlock()
+		writeFrame(c.Conn, wsClose, binary.BigEndian.AppendUint16(nil, code), nil)
+	})
+}
+
+func (c *wsConn) Close() error {
+	c.closeWith(wsNormalClosure)
+	return c.Conn.Close()
+}
+
+// wsHandler serves the chat page, and puts WebSockets opened from it into
+// rooms.
+func wsHandler(rooms *Rooms) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, CHAT_PAGE)
+	})
+	mux.HandleFunc("/chat", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrade(w, r)
+		if err != nil {
+			log.Println("websocket upgrade failed", err)
+			return
+		}
+		handleConnection(conn, rooms)
+	})
+	return mux
+}
+
+const CHAT_PAGE = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Budget Chat</title></head>
+<body>
+<pre id="log"></pre>
+<form id="form"><input id="line" size="80" autofocus autocomplete="off"></form>
+<script>
+const log = document.getElementById("log");
+const line = document.getElementById("line");
+const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/chat");
+ws.onmessage = e => { log.textContent += e.data + "\n"; window.scrollTo(0, document.body.scrollHeight); };
+ws.onclose = () => { log.textContent += "* Disconnected.\n"; };
+document.getElementById("form").onsubmit = e => {
+	e.preventDefault();
+	ws.send(line.value);
+	line.value = "";
+};
+</script>
+</body>
+</html>
+`
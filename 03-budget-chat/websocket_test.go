@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWSAcceptKey(t *testing.T) {
+	// the example from RFC 6455 section 1.3
+	if got := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestFrames(t *testing.T) {
+	mask := []byte{1, 2, 3, 4}
+	for _, size := range []int{0, 5, 125, 126, 1000, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte("abcdefg"), size/7+1)[:size]
+		for _, m := range [][]byte{nil, mask} {
+			var buf bytes.Buffer
+			if err := writeFrame(&buf, wsText, payload, m); err != nil {
+				t.Fatal(err)
+			}
+			f, err := readFrame(&buf, 1<<20)
+			if err != nil {
+				t.Fatalf("size %d: %v", size, err)
+			}
+			if !f.fin || f.opcode != wsText || f.masked != (m != nil) || !bytes.Equal(f.payload, payload) {
+				t.Errorf("size %d, mask %v: got %+v", size, m, f)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	writeFrame(&buf, wsText, make([]byte, 100), nil)
+	if _, err := readFrame(&buf, 99); !errors.Is(err, ErrWSFrameTooLarge) {
+		t.Errorf("oversized frame: got %v", err)
+	}
+	buf.Reset()
+	writeFrame(&buf, wsPing, make([]byte, 126), nil)
+	if _, err := readFrame(&buf, 1000); !errors.Is(err, ErrWSProtocol) {
+		t.Errorf("oversized ping: got %v", err)
+	}
+}
+
+// wsClient is the browser's end of a WebSocket.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWS(t *testing.T, url string) *wsClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake got %s", resp.Status)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("bad accept key %q", got)
+	}
+	return &wsClient{t, conn, r}
+}
+
+func (c *wsClient) send(opcode byte, payload string) {
+	writeFrame(c.conn, opcode, []byte(payload), []byte{0x12, 0x34, 0x56, 0x78})
+}
+
+// expect reads a frame and checks its opcode and payload.
+func (c *wsClient) expect(opcode byte, substr string) string {
+	c.t.Helper()
+	f, err := readFrame(c.r, 1<<20)
+	if err != nil {
+		c.t.Fatalf("waiting for %q: %v", substr, err)
+	}
+	if f.masked {
+		c.t.Fatal("server sent a masked frame")
+	}
+	if f.opcode != opcode || !strings.Contains(string(f.payload), substr) {
+		c.t.Fatalf("expected opcode %d with %q, got %d with %q", opcode, substr, f.opcode, f.payload)
+	}
+	return string(f.payload)
+}
+
+func TestWebSocketChat(t *testing.T) {
+	rooms := newRooms()
+	server := httptest.NewServer(wsHandler(rooms))
+	defer server.Close()
+
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+
+	bob := dialWS(t, server.URL)
+	defer bob.conn.Close()
+	if got := bob.expect(wsText, "Welcome"); got != strings.TrimSuffix(GREETING, "\n") {
+		t.Errorf("greeting frame %q", got)
+	}
+	bob.send(wsText, "bob")
+	bob.expect(wsText, "* The room contains: alice")
+	alice.expect("bob has entered the room")
+
+	alice.send("hi bob")
+	bob.expect(wsText, "[alice] hi bob")
+
+	bob.send(wsPing, "are you there")
+	bob.expect(wsPong, "are you there")
+
+	// hanging up without a close frame is fine too
+	bob.conn.Close()
+	alice.expect("bob has left the room")
+}
+
+func TestWebSocketFragments(t *testing.T) {
+	rooms := newRooms()
+	server := httptest.NewServer(wsHandler(rooms))
+	defer server.Close()
+	alice := connectClient(t, rooms, "alice")
+	defer alice.conn.Close()
+	bob := dialWS(t, server.URL)
+	defer bob.conn.Close()
+	bob.expect(wsText, "Welcome")
+	bob.send(wsText, "bob")
+	bob.expect(wsText, "The room contains")
+	alice.expect("bob has entered the room")
+
+	// "hello " unfinished, a ping in between, then "alice" to finish
+	mask := []byte{9, 8, 7, 6}
+	var frames bytes.Buffer
+	writeFrame(&frames, wsText, []byte("hello "), mask)
+	frames.Bytes()[0] &^= 0x80
+	writeFrame(&frames, wsPing, nil, mask)
+	writeFrame(&frames, wsContinuation, []byte("alice"), mask)
+	bob.conn.Write(frames.Bytes())
+	bob.expect(wsPong, "")
+	alice.expect("[bob] hello alice")
+
+	bob.send(wsClose, "\x03\xe8")
+	bob.expect(wsClose, "\x03\xe8")
+	alice.expect("bob has left the room")
+}
+
+func TestWebSocketUnmasked(t *testing.T) {
+	rooms := newRooms()
+	server := httptest.NewServer(wsHandler(rooms))
+	defer server.Close()
+	bob := dialWS(t, server.URL)
+	defer bob.conn.Close()
+	bob.expect(wsText, "Welcome")
+	writeFrame(bob.conn, wsText, []byte("bob"), nil)
+	bob.expect(wsClose, "\x03\xea")
+	if _, err := readFrame(bob.r, 1<<20); err != io.EOF {
+		t.Errorf("expected the connection to close, got %v", err)
+	}
+}
+
+func TestNotWebSocket(t *testing.T) {
+	rooms := newRooms()
+	server := httptest.NewServer(wsHandler(rooms))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET of /chat got %s", resp.Status)
+	}
+	resp, err = http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "new WebSocket") {
+		t.Error("chat page missing")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extensions are requests beyond the spec's inserts and queries. They all
+// start with "!", and with none enabled, which is the default, those
+// requests are ordinary keys like any other:
+//
+//	!list <prefix>                     replies "!list <prefix>=" and matching keys, one per line
+//	!delete <key>                      deletes key, with no reply
+//	!ttl <seconds> <key>=<value>       inserts a value that expires, with no reply
+//
+// A list reply has as many keys, in order, as fit in one datagram.
+type Extensions uint8
+
+const (
+	ExtList Extensions = 1 << iota
+	ExtDelete
+	ExtTTL
+	ExtAll = ExtList | ExtDelete | ExtTTL
+)
+
+const EXT_PREFIX = "!"
+
+var ErrUnknownExtension = errors.New("unknown extension")
+var ErrBadExtension = errors.New("bad extension request")
+
+var extensionNames = []struct {
+	ext  Extensions
+	name string
+}{
+	{ExtList, "list"},
+	{ExtDelete, "delete"},
+	{ExtTTL, "ttl"},
+}
+
+func (e Extensions) String() string {
+	names := []string{}
+	for _, x := range extensionNames {
+		if e&x.ext != 0 {
+			names = append(names, x.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseExtensions reads a comma-separated list of extension names, or
+// "all".
+func ParseExtensions(s string) (Extensions, error) {
+	var e Extensions
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			e |= ExtAll
+			continue
+		}
+		found := false
+		for _, x := range extensionNames {
+			if name == x.name {
+				e |= x.ext
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("%w %q", ErrUnknownExtension, name)
+		}
+	}
+	return e, nil
+}
+
+// Set lets Extensions be a flag.Value.
+func (e *Extensions) Set(s string) error {
+	v, err := ParseExtensions(s)
+	if err != nil {
+		return err
+	}
+	*e = v
+	return nil
+}
+
+// handleExtension handles req if it's an enabled extension, and reports
+// whether it was.
+func (n *Node) handleExtension(req string, source net.Addr) ([]byte, bool, error) {
+	command, args, _ := strings.Cut(strings.TrimPrefix(req, EXT_PREFIX), " ")
+	switch {
+	case command == "list" && n.Extensions&ExtList != 0:
+		log.Printf("[%s]\tList %s\n", source, args)
+		res := []byte(req + "=")
+		for i, key := range n.store.Keys(args) {
+			if len(res)+1+len(key) >= MAX_REQUEST {
+				break
+			}
+			if i > 0 {
+				res = append(res, '\n')
+			}
+			res = append(res, key...)
+		}
+		return res, true, nil
+	case command == "delete" && n.Extensions&ExtDelete != 0:
+		log.Printf("[%s]\tDelete %s\n", source, args)
+		n.write(Write{Key: args, Deleted: true})
+		return []byte{}, true, nil
+	case command == "ttl" && n.Extensions&ExtTTL != 0:
+		seconds, insert, _ := strings.Cut(args, " ")
+		ttl, err := strconv.ParseUint(seconds, 10, 32)
+		if err != nil || ttl == 0 {
+			return nil, true, fmt.Errorf("%w: TTL %q", ErrBadExtension, seconds)
+		}
+		key, value, ok := strings.Cut(insert, "=")
+		if !ok {
+			return nil, true, fmt.Errorf("%w: no value in %q", ErrBadExtension, insert)
+		}
+		log.Printf("[%s]\tInsert for %ds: %s=%s\n", source, ttl, key, value)
+		n.write(Write{Key: key, Value: value, Expires: n.store.now().Add(time.Duration(ttl) * time.Second)})
+		return []byte{}, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExtensions(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		in   string
+		want Extensions
+	}{
+		{"", 0},
+		{"list", ExtList},
+		{"ttl, delete", ExtDelete | ExtTTL},
+		{"all", ExtAll},
+	}
+	for _, c := range cases {
+		got, err := ParseExtensions(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("ParseExtensions(%q) = %v, %v", c.in, got, err)
+		}
+	}
+	if _, err := ParseExtensions("list,teleport"); !errors.Is(err, ErrUnknownExtension) {
+		t.Errorf("expected ErrUnknownExtension, got %v", err)
+	}
+	if s := ExtAll.String(); s != "list,delete,ttl" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func request(t *testing.T, n *Node, req string) string {
+	t.Helper()
+	res, err := n.handleRequest([]byte(req), &net.IPAddr{IP: net.ParseIP("0.0.0.0")})
+	if err != nil {
+		t.Fatalf("%q: %v", req, err)
+	}
+	return string(res)
+}
+
+func TestStrictMode(t *testing.T) {
+	t.Parallel()
+	n := newNode("test", nil, nil, NewStore(Limits{}))
+	request(t, n, "!ttl 1 k=v")
+	request(t, n, "!delete k")
+	if got := request(t, n, "!ttl 1 k"); got != "!ttl 1 k=v" {
+		t.Errorf("got %q", got)
+	}
+	if got := request(t, n, "!list "); got != "!list =" {
+		t.Errorf("got %q", got)
+	}
+	if got := request(t, n, VERSION_KEY); got != "version=zudp-1.0" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	t.Parallel()
+	store := NewStore(Limits{})
+	now := time.Unix(1000, 0)
+	store.now = func() time.Time { return now }
+	n := newNode("test", nil, nil, store)
+	n.Extensions = ExtAll
+
+	if got := request(t, n, VERSION_KEY); got != "version=zudp-1.0 ext=list,delete,ttl" {
+		t.Errorf("got %q", got)
+	}
+	for _, req := range []string{"fruit/apple=1", "fruit/pear=2", "veg/leek=3", "!ttl 10 fruit/fig=4"} {
+		request(t, n, req)
+	}
+	if got := request(t, n, "!list fruit/"); got != "!list fruit/=fruit/apple\nfruit/fig\nfruit/pear" {
+		t.Errorf("got %q", got)
+	}
+	request(t, n, "!delete fruit/apple")
+	if got := request(t, n, "fruit/apple"); got != "fruit/apple=" {
+		t.Errorf("got %q", got)
+	}
+	now = now.Add(10 * time.Second)
+	if got := request(t, n, "fruit/fig"); got != "fruit/fig=" {
+		t.Errorf("expired key: got %q", got)
+	}
+	if got := request(t, n, "!list fruit/"); got != "!list fruit/=fruit/pear" {
+		t.Errorf("got %q", got)
+	}
+	// an insert after a delete brings the key back
+	request(t, n, "fruit/apple=5")
+	if got := request(t, n, "fruit/apple"); got != "fruit/apple=5" {
+		t.Errorf("got %q", got)
+	}
+	// version can't be deleted
+	request(t, n, "!delete version")
+	if got := request(t, n, VERSION_KEY); !strings.HasPrefix(got, "version=zudp-1.0") {
+		t.Errorf("got %q", got)
+	}
+
+	for _, bad := range []string{"!ttl soon k=v", "!ttl 0 k=v", "!ttl 5 k"} {
+		if _, err := n.handleRequest([]byte(bad), &net.IPAddr{}); !errors.Is(err, ErrBadExtension) {
+			t.Errorf("%q: expected ErrBadExtension, got %v", bad, err)
+		}
+	}
+}
+
+func TestListFits(t *testing.T) {
+	t.Parallel()
+	n := newNode("test", nil, nil, NewStore(Limits{}))
+	n.Extensions = ExtList
+	for i := 0; i < 30; i++ {
+		request(t, n, strings.Repeat(string(rune('a'+i%26)), 50+i)+"=x")
+	}
+	got := request(t, n, "!list ")
+	if len(got) >= MAX_REQUEST {
+		t.Errorf("reply is %d bytes", len(got))
+	}
+	keys := strings.Split(strings.TrimPrefix(got, "!list ="), "\n")
+	if len(keys) < 10 || len(keys) == 30 {
+		t.Errorf("expected some of the keys, got %d", len(keys))
+	}
+}
@@ -1,34 +1,95 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"hash/fnv"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"runtime"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-var crap map[string]string
+const VERSION_KEY = "version"
+const VERSION = "zudp-1.0"
 
-func handleRequest(req []byte, source net.Addr) ([]byte, error) {
+// MAX_REQUEST is the longest request the spec lets clients send. Replication
+// messages add a timestamp, so they get a little more room.
+const MAX_REQUEST = 1000
+const MAX_DATAGRAM = 2048
+
+// WORKER_QUEUE is how many datagrams can wait for each worker before Serve
+// stops reading more.
+const WORKER_QUEUE = 64
+
+// Node is one replica of the database. Inserts made on any node reach the
+// others, and the last writer wins.
+type Node struct {
+	ID string
+	// Workers is how many datagrams Serve handles at once. 0 means one
+	// per CPU.
+	Workers int
+	// Extensions are the requests beyond the spec that are allowed.
+	Extensions Extensions
+	peers      []net.Addr
+	// peerAddrs is peers as strings, to recognise replication messages.
+	peerAddrs map[string]bool
+	conn      net.PacketConn
+	store     *Store
+
+	lock  sync.Mutex
+	clock uint64
+}
+
+// newNode makes a node that talks on conn, which may be nil if there are no
+// peers, and keeps its data in store. Its clock carries on from the latest
+// insert in store.
+func newNode(id string, conn net.PacketConn, peers []net.Addr, store *Store) *Node {
+	n := &Node{
+		ID:        id,
+		peers:     peers,
+		peerAddrs: make(map[string]bool),
+		conn:      conn,
+		clock:     store.LastTime(),
+		store:     store,
+	}
+	for _, peer := range peers {
+		n.peerAddrs[peer.String()] = true
+	}
+	return n
+}
+
+func (n *Node) handleRequest(req []byte, source net.Addr) ([]byte, error) {
 	sreq := string(req)
+	if n.Extensions != 0 && strings.HasPrefix(sreq, EXT_PREFIX) {
+		if res, ok, err := n.handleExtension(sreq, source); ok {
+			return res, err
+		}
+	}
 	before, after, had_equals := strings.Cut(sreq, "=")
 	if had_equals {
 		log.Printf("[%s]\tInsert: %s=%s\n", source, before, after)
 		// insert
-		if before == "version" {
+		if before == VERSION_KEY {
 		} else {
-			crap[before] = after
+			n.write(Write{Key: before, Value: after})
 		}
 		return []byte{}, nil
 	} else {
 		// query
-		if before == "version" {
+		if before == VERSION_KEY {
 			log.Printf("[%s]\tVersion query\n", source)
-			return []byte("version=zudp-1.0"), nil
+			return []byte(n.version()), nil
 		} else {
 			log.Printf("[%s]\tQuery for %s\n", source, before)
 			res := append([]byte(before), "="...)
-			if val, ok := crap[before]; ok {
-				res = append(res, []byte(val)...)
+			if value, ok := n.store.Get(before); ok {
+				res = append(res, []byte(value)...)
 			}
 			log.Printf("[%s]\tQuery returned %s\n", source, res)
 			return res, nil
@@ -36,36 +97,167 @@ func handleRequest(req []byte, source net.Addr) ([]byte, error) {
 	}
 }
 
-func resetForTesting() {
-	crap = make(map[string]string)
+// version is the reply to a version query, which lists the enabled
+// extensions if there are any.
+func (n *Node) version() string {
+	if n.Extensions == 0 {
+		return VERSION_KEY + "=" + VERSION
+	}
+	return VERSION_KEY + "=" + VERSION + " ext=" + n.Extensions.String()
 }
 
-func main() {
-	crap = make(map[string]string)
+// write makes a write from a client, timestamping it and telling the peers
+// about it.
+func (n *Node) write(w Write) {
+	w.TS = n.tick()
+	if n.store.Apply(w) {
+		n.replicate(w)
+	}
+}
 
-	conn, err := net.ListenPacket("udp", ":1337")
-	if err != nil {
-		log.Fatal(err)
+// tick moves the clock on for a local write.
+func (n *Node) tick() Timestamp {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.clock++
+	return Timestamp{Time: n.clock, Node: n.ID}
+}
+
+// observe moves the clock up to a time seen from a peer.
+func (n *Node) observe(time uint64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if time > n.clock {
+		n.clock = time
 	}
-	defer conn.Close()
+}
+
+type datagram struct {
+	data []byte
+	addr net.Addr
+}
+
+// Serve handles requests and replication messages arriving on n.conn until
+// it's closed, with n.Workers of them at once. Datagrams from one address
+// always go to the same worker, so each client's requests, and each peer's
+// inserts, are still handled in the order they arrived.
+func (n *Node) Serve() error {
+	workers := n.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	queues := make([]chan datagram, workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan datagram, WORKER_QUEUE)
+		wg.Add(1)
+		go func(queue chan datagram) {
+			defer wg.Done()
+			for d := range queue {
+				n.handleDatagram(d.data, d.addr)
+			}
+		}(queues[i])
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
 
 	for {
-		buf := make([]byte, 1024)
-		n, addr, err := conn.ReadFrom(buf)
+		buf := make([]byte, MAX_DATAGRAM)
+		nread, addr, err := n.conn.ReadFrom(buf)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
 			continue
 		}
-		if n >= 1000 {
-			continue
+		h := fnv.New32a()
+		h.Write([]byte(addr.String()))
+		queues[h.Sum32()%uint32(workers)] <- datagram{data: buf[:nread], addr: addr}
+	}
+}
+
+func (n *Node) handleDatagram(data []byte, addr net.Addr) {
+	if n.peerAddrs[addr.String()] {
+		if err := n.handleReplication(data, addr); err != nil {
+			log.Printf("[%s]\tBad replication message: %s\n", addr, err)
 		}
-		log.Printf("[%s] len(buf) was %d, n was %d", addr, len(buf), n)
-		result, err := handleRequest(buf[:n], addr)
+		return
+	}
+	if len(data) >= MAX_REQUEST {
+		return
+	}
+	log.Printf("[%s]\tGot %d bytes\n", addr, len(data))
+	result, err := n.handleRequest(data, addr)
+	if err != nil {
+		log.Printf("Got error serving %s: %s\n", addr, err)
+		return
+	}
+	if len(result) > 0 {
+		n.conn.WriteTo(result, addr)
+	}
+}
+
+func main() {
+	listen := flag.String("listen", ":1337", "UDP address to listen on")
+	id := flag.String("id", "", "this node's name, unique among its peers (default the listen address)")
+	peerList := flag.String("peers", "", "comma-separated UDP addresses of the other nodes to replicate to")
+	dataDir := flag.String("data", "", "directory to keep the database in (default memory only)")
+	snapshotEvery := flag.Duration("snapshot", time.Minute, "how often to snapshot the database")
+	maxKeys := flag.Int("maxkeys", 100_000, "most keys to keep before evicting the least recently used (0 for no limit)")
+	maxBytes := flag.Int("maxbytes", 64<<20, "most bytes of keys and values to keep before evicting (0 for no limit)")
+	workers := flag.Int("workers", runtime.NumCPU(), "how many datagrams to handle at once")
+	var extensions Extensions
+	flag.Var(&extensions, "ext", "comma-separated extensions to allow: list, delete, ttl or all (default none, as the spec says)")
+	flag.Parse()
+	if *id == "" {
+		*id = *listen
+	}
+	if strings.Contains(*id, " ") {
+		log.Fatal("node ID can't contain spaces")
+	}
+	peers, err := ParsePeers(*peerList)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	limits := Limits{MaxKeys: *maxKeys, MaxBytes: *maxBytes}
+	store := NewStore(limits)
+	if *dataDir != "" {
+		store, err = OpenStore(*dataDir, limits)
 		if err != nil {
-			log.Printf("Got error serving %s: %s\n", addr, err)
-			continue
-		}
-		if len(result) > 0 {
-			conn.WriteTo(result, addr)
+			log.Fatal("could not open database: ", err)
 		}
+		log.Printf("Restored %d keys from %s\n", store.Len(), *dataDir)
+		go func() {
+			for range time.Tick(*snapshotEvery) {
+				if err := store.Snapshot(); err != nil {
+					log.Println("Snapshot failed:", err)
+				}
+			}
+		}()
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-stop
+			if err := store.Close(); err != nil {
+				log.Println("Final snapshot failed:", err)
+			}
+			os.Exit(0)
+		}()
 	}
+
+	conn, err := net.ListenPacket("udp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	node := newNode(*id, conn, peers, store)
+	node.Workers = *workers
+	node.Extensions = extensions
+	log.Fatal(node.Serve())
 }
@@ -2,16 +2,20 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"net"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestSmokeTest(t *testing.T) {
-	resetForTesting()
+	t.Parallel()
+	n := newNode("test", nil, nil, NewStore(Limits{}))
 	source := net.IPAddr{
 		IP: net.ParseIP("0.0.0.0"),
 	}
-	res, err := handleRequest([]byte("foo"), &source)
+	res, err := n.handleRequest([]byte("foo"), &source)
 	if err != nil {
 		t.Error("should not have failed to query foo")
 	}
@@ -21,11 +25,12 @@ func TestSmokeTest(t *testing.T) {
 }
 
 func TestStoreItem(t *testing.T) {
-	resetForTesting()
+	t.Parallel()
+	n := newNode("test", nil, nil, NewStore(Limits{}))
 	source := net.IPAddr{
 		IP: net.ParseIP("0.0.0.0"),
 	}
-	res, err := handleRequest([]byte("foo=bar"), &source)
+	res, err := n.handleRequest([]byte("foo=bar"), &source)
 	if err != nil {
 		t.Fatal("should not have failed to store foo")
 	}
@@ -33,7 +38,7 @@ func TestStoreItem(t *testing.T) {
 		t.Error("should not have gotten reply to store")
 	}
 
-	res, err = handleRequest([]byte("foo"), &source)
+	res, err = n.handleRequest([]byte("foo"), &source)
 	if err != nil {
 		t.Error("should not have failed to query foo")
 	}
@@ -43,11 +48,12 @@ func TestStoreItem(t *testing.T) {
 }
 
 func TestStoreFancyItem(t *testing.T) {
-	resetForTesting()
+	t.Parallel()
+	n := newNode("test", nil, nil, NewStore(Limits{}))
 	source := net.IPAddr{
 		IP: net.ParseIP("0.0.0.0"),
 	}
-	res, err := handleRequest([]byte("foo=bar=1"), &source)
+	res, err := n.handleRequest([]byte("foo=bar=1"), &source)
 	if err != nil {
 		t.Fatal("should not have failed to store foo")
 	}
@@ -55,7 +61,7 @@ func TestStoreFancyItem(t *testing.T) {
 		t.Error("should not have gotten reply to store")
 	}
 
-	res, err = handleRequest([]byte("foo"), &source)
+	res, err = n.handleRequest([]byte("foo"), &source)
 	if err != nil {
 		t.Error("should not have failed to query foo")
 	}
@@ -63,3 +69,52 @@ func TestStoreFancyItem(t *testing.T) {
 		t.Error("should have been a value for foo")
 	}
 }
+
+func TestConcurrentClients(t *testing.T) {
+	t.Parallel()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	n := newNode("test", conn, nil, NewStore(Limits{}))
+	n.Workers = 4
+	go n.Serve()
+
+	const clients = 20
+	const inserts = 10
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer client.Close()
+			key := fmt.Sprintf("client%d", i)
+			buf := make([]byte, MAX_DATAGRAM)
+			for j := 0; j < inserts; j++ {
+				want := fmt.Sprintf("%s=%d", key, j)
+				// a client's requests are handled in order, so the
+				// query sees the insert, unless either got lost
+				for try := 0; ; try++ {
+					client.WriteTo([]byte(want), conn.LocalAddr())
+					client.WriteTo([]byte(key), conn.LocalAddr())
+					client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+					nread, _, err := client.ReadFrom(buf)
+					if err == nil && string(buf[:nread]) == want {
+						break
+					}
+					if try == 10 {
+						t.Errorf("never got %q back, last %q, %v", want, buf[:nread], err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadReplication = errors.New("bad replication message")
+
+// Timestamp orders inserts across nodes. Time is a Lamport clock, and Node
+// breaks ties between inserts made at the same time on different nodes, so
+// every node picks the same last writer.
+type Timestamp struct {
+	Time uint64
+	Node string
+}
+
+func (t Timestamp) Before(o Timestamp) bool {
+	if t.Time != o.Time {
+		return t.Time < o.Time
+	}
+	return t.Node < o.Node
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d@%s", t.Time, t.Node)
+}
+
+// A replication message is a write along with its timestamp. Inserts give
+// when they expire, in Unix nanoseconds, or 0 if they don't:
+//
+//	<time> <node> <expires> <key>=<value>
+//	<time> <node> - <key>
+//
+// Peers are told about every write a node accepts from a client. They're
+// recognised by the address they send from, so client requests can't be
+// mistaken for them.
+func formatReplication(w Write) []byte {
+	if w.Deleted {
+		return []byte(fmt.Sprintf("%d %s - %s", w.TS.Time, w.TS.Node, w.Key))
+	}
+	var expires int64
+	if !w.Expires.IsZero() {
+		expires = w.Expires.UnixNano()
+	}
+	return []byte(fmt.Sprintf("%d %s %d %s=%s", w.TS.Time, w.TS.Node, expires, w.Key, w.Value))
+}
+
+func parseReplication(msg []byte) (w Write, err error) {
+	fields := strings.SplitN(string(msg), " ", 4)
+	if len(fields) != 4 || fields[1] == "" {
+		return w, fmt.Errorf("%w: %q", ErrBadReplication, msg)
+	}
+	w.TS.Time, err = strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return w, fmt.Errorf("%w: %v", ErrBadReplication, err)
+	}
+	w.TS.Node = fields[1]
+	if fields[2] == "-" {
+		w.Key = fields[3]
+		w.Deleted = true
+		return w, nil
+	}
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return w, fmt.Errorf("%w: %v", ErrBadReplication, err)
+	}
+	if expires != 0 {
+		w.Expires = time.Unix(0, expires)
+	}
+	var ok bool
+	w.Key, w.Value, ok = strings.Cut(fields[3], "=")
+	if !ok {
+		return w, fmt.Errorf("%w: %q", ErrBadReplication, msg)
+	}
+	return w, nil
+}
+
+// ParsePeers resolves a comma-separated list of peer addresses.
+func ParsePeers(list string) ([]net.Addr, error) {
+	peers := []net.Addr{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", s)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, addr)
+	}
+	return peers, nil
+}
+
+// replicate tells every peer about a write.
+func (n *Node) replicate(w Write) {
+	if n.conn == nil {
+		return
+	}
+	msg := formatReplication(w)
+	for _, peer := range n.peers {
+		if _, err := n.conn.WriteTo(msg, peer); err != nil {
+			log.Printf("[%s]\tFailed to replicate %s: %s\n", peer, w.TS, err)
+		}
+	}
+}
+
+// handleReplication applies a write a peer told us about.
+func (n *Node) handleReplication(msg []byte, source net.Addr) error {
+	w, err := parseReplication(msg)
+	if err != nil {
+		return err
+	}
+	n.observe(w.TS.Time)
+	if n.store.Apply(w) {
+		log.Printf("[%s]\tReplicated %s for %s\n", source, w.TS, w.Key)
+	} else {
+		log.Printf("[%s]\tIgnored replicated %s for %s\n", source, w.TS, w.Key)
+	}
+	return nil
+}
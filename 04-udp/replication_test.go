@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplicationMessages(t *testing.T) {
+	t.Parallel()
+	writes := []Write{
+		{Key: "key", Value: "value=with=equals", TS: Timestamp{Time: 42, Node: "a"}},
+		{Key: "key", Value: "soon gone", TS: Timestamp{Time: 43, Node: "a"}, Expires: time.Unix(1700000000, 5)},
+		{Key: "key with spaces", TS: Timestamp{Time: 44, Node: "b"}, Deleted: true},
+	}
+	for _, w := range writes {
+		got, err := parseReplication(formatReplication(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Key != w.Key || got.Value != w.Value || got.TS != w.TS || !got.Expires.Equal(w.Expires) || got.Deleted != w.Deleted {
+			t.Errorf("got %+v, want %+v", got, w)
+		}
+	}
+	for _, bad := range []string{"", "42 a 0", "x a 0 k=v", "42 a 0 novalue", "42  0 k=v", "42 a soon k=v"} {
+		if _, err := parseReplication([]byte(bad)); !errors.Is(err, ErrBadReplication) {
+			t.Errorf("%q: expected ErrBadReplication, got %v", bad, err)
+		}
+	}
+}
+
+func TestLastWriterWins(t *testing.T) {
+	t.Parallel()
+	n := newNode("b", nil, nil, NewStore(Limits{}))
+	source := &net.IPAddr{IP: net.ParseIP("0.0.0.0")}
+	n.handleReplication(formatReplication(Write{Key: "k", Value: "first", TS: Timestamp{5, "a"}}), source)
+	// older, then the same time from a node that loses the tie
+	n.handleReplication(formatReplication(Write{Key: "k", Value: "old", TS: Timestamp{4, "c"}}), source)
+	n.handleReplication(formatReplication(Write{Key: "k", Value: "tie", TS: Timestamp{5, "0"}}), source)
+	res, _ := n.handleRequest([]byte("k"), source)
+	if string(res) != "k=first" {
+		t.Errorf("got %q", res)
+	}
+	// a local insert comes after anything we've seen
+	n.handleRequest([]byte("k=local"), source)
+	if n.store.Put("k", "stale", Timestamp{6, "a"}) {
+		t.Error("expected local insert to be at 6@b")
+	}
+	if value, _ := n.store.Get("k"); value != "local" {
+		t.Errorf("got %q", value)
+	}
+	// and version can't be replicated in
+	n.handleReplication(formatReplication(Write{Key: VERSION_KEY, Value: "evil", TS: Timestamp{99, "a"}}), source)
+	if res, _ := n.handleRequest([]byte(VERSION_KEY), source); string(res) != "version=zudp-1.0" {
+		t.Errorf("got %q", res)
+	}
+}
+
+// startCluster runs size nodes on localhost that all replicate to each
+// other.
+func startCluster(t *testing.T, size int, ext Extensions) []net.Addr {
+	conns := []net.PacketConn{}
+	addrs := []net.Addr{}
+	for i := 0; i < size; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conns = append(conns, conn)
+		addrs = append(addrs, conn.LocalAddr())
+	}
+	for i, conn := range conns {
+		peers := []net.Addr{}
+		for j, addr := range addrs {
+			if i != j {
+				peers = append(peers, addr)
+			}
+		}
+		n := newNode(string(rune('a'+i)), conn, peers, NewStore(Limits{}))
+		n.Extensions = ext
+		go n.Serve()
+	}
+	return addrs
+}
+
+func query(t *testing.T, client net.PacketConn, node net.Addr, key string) string {
+	t.Helper()
+	if _, err := client.WriteTo([]byte(key), node); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, MAX_DATAGRAM)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+// converge waits for every node to give the same answer for key.
+func converge(t *testing.T, client net.PacketConn, nodes []net.Addr, key string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		answers := map[string]bool{}
+		var last string
+		for _, node := range nodes {
+			last = query(t, client, node, key)
+			answers[last] = true
+		}
+		if len(answers) == 1 {
+			return last
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no agreement on %s: %v", key, answers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	t.Parallel()
+	nodes := startCluster(t, 3, 0)
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.WriteTo([]byte("foo=bar"), nodes[0])
+	if got := converge(t, client, nodes, "foo"); got != "foo=bar" {
+		t.Errorf("got %q", got)
+	}
+
+	// concurrent inserts on different nodes end up the same everywhere
+	client.WriteTo([]byte("race=one"), nodes[1])
+	client.WriteTo([]byte("race=two"), nodes[2])
+	got := converge(t, client, nodes, "race")
+	if got != "race=one" && got != "race=two" {
+		t.Errorf("got %q", got)
+	}
+
+	// a later insert wins over both
+	client.WriteTo([]byte("race=three"), nodes[0])
+	deadline := time.Now().Add(5 * time.Second)
+	for converge(t, client, nodes, "race") != "race=three" {
+		if time.Now().After(deadline) {
+			t.Fatal("later insert never won")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// version stays local
+	client.WriteTo([]byte("version=hacked"), nodes[0])
+	if got := query(t, client, nodes[1], VERSION_KEY); got != "version=zudp-1.0" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestClusterDelete(t *testing.T) {
+	t.Parallel()
+	nodes := startCluster(t, 2, ExtAll)
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.WriteTo([]byte("!ttl 60 k=v"), nodes[0])
+	if got := converge(t, client, nodes, "k"); got != "k=v" {
+		t.Errorf("got %q", got)
+	}
+	client.WriteTo([]byte("!delete k"), nodes[1])
+	deadline := time.Now().Add(5 * time.Second)
+	for converge(t, client, nodes, "k") != "k=" {
+		if time.Now().After(deadline) {
+			t.Fatal("delete never reached every node")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
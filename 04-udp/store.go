@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SNAPSHOT_FILE = "snapshot.jsonl"
+const LOG_FILE = "log.jsonl"
+
+// Limits bounds a Store. Zero means no limit.
+type Limits struct {
+	MaxKeys int
+	// MaxBytes counts the lengths of keys and values.
+	MaxBytes int
+}
+
+// Write is one change to a key.
+type Write struct {
+	Key   string
+	Value string
+	TS    Timestamp
+	// Expires, if set, is when the value stops being visible.
+	Expires time.Time
+	// Deleted marks a delete. Deletes are kept like inserts, so that an
+	// older insert that turns up later loses to them.
+	Deleted bool
+}
+
+func (w *Write) size() int {
+	return len(w.Key) + len(w.Value)
+}
+
+// visible reports whether w has a value at now.
+func (w *Write) visible(now time.Time) bool {
+	return !w.Deleted && (w.Expires.IsZero() || now.Before(w.Expires))
+}
+
+// record is how writes are saved to disk, one JSON object per line.
+type record struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Time    uint64 `json:"time"`
+	Node    string `json:"node"`
+	Expires int64  `json:"expires,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+func (r record) write() Write {
+	w := Write{Key: r.Key, Value: r.Value, TS: Timestamp{Time: r.Time, Node: r.Node}, Deleted: r.Deleted}
+	if r.Expires != 0 {
+		w.Expires = time.Unix(0, r.Expires)
+	}
+	return w
+}
+
+// Store holds the keys and values, evicting the least recently used keys to
+// stay within its Limits. If it has a directory, every write is appended to
+// a log there, and Snapshot writes everything out and starts a new log, so
+// the store can be restored after a restart. The version key is never
+// stored. It's safe for concurrent use.
+type Store struct {
+	limits Limits
+	dir    string
+	// now is the time, for expiry.
+	now func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	// lru has the most recently used entry at the front
+	lru   *list.List
+	bytes int
+	log   *os.File
+	// lastTime is the latest timestamp seen, so a restored node's clock
+	// can carry on from it.
+	lastTime uint64
+}
+
+// NewStore makes a store that only lives in memory.
+func NewStore(limits Limits) *Store {
+	return &Store{
+		limits:  limits,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// OpenStore restores the store kept in dir, making dir if need be.
+func OpenStore(dir string, limits Limits) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := NewStore(limits)
+	s.dir = dir
+	if _, err := s.restore(filepath.Join(dir, SNAPSHOT_FILE)); err != nil {
+		return nil, err
+	}
+	logPath := filepath.Join(dir, LOG_FILE)
+	end, err := s.restore(logPath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// cut off any incomplete last line, so the next record starts a line
+	// of its own
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.log = f
+	return s, nil
+}
+
+// restore applies every record in path, and returns the offset just after
+// the last complete line. A partly written last line, from a crash during an
+// append, is skipped.
+func (s *Store) restore(path string) (int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var end int64
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(data) > 0 {
+				log.Printf("Skipping incomplete last line of %s\n", path)
+			}
+			return end, nil
+		} else if err != nil {
+			return 0, err
+		}
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return 0, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		s.apply(rec.write())
+		end += int64(len(data))
+	}
+}
+
+// Get returns the value for key, and marks it as recently used.
+func (s *Store) Get(key string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return "", false
+	}
+	s.lru.MoveToFront(el)
+	w := el.Value.(*Write)
+	if !w.visible(s.now()) {
+		return "", false
+	}
+	return w.Value, true
+}
+
+// Keys returns the keys that start with prefix and have a value, sorted.
+func (s *Store) Keys(prefix string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	keys := []string{}
+	for key, el := range s.entries {
+		if strings.HasPrefix(key, prefix) && el.Value.(*Write).visible(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Put stores value for key if ts is later than the key's current
+// timestamp, and reports whether it did.
+func (s *Store) Put(key, value string, ts Timestamp) bool {
+	return s.Apply(Write{Key: key, Value: value, TS: ts})
+}
+
+// Apply makes a write if it's later than the last write to its key, and
+// reports whether it did. Keys that have been evicted are forgotten
+// entirely, so any write to them counts as later.
+func (s *Store) Apply(w Write) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.apply(w) {
+		return false
+	}
+	if s.log != nil {
+		if err := writeRecord(s.log, &w); err != nil {
+			log.Println("Failed to log write:", err)
+		}
+	}
+	return true
+}
+
+func (s *Store) apply(w Write) bool {
+	if w.TS.Time > s.lastTime {
+		s.lastTime = w.TS.Time
+	}
+	if w.Key == VERSION_KEY {
+		return false
+	}
+	e := &w
+	if s.limits.MaxBytes > 0 && e.size() > s.limits.MaxBytes {
+		return false
+	}
+	if el, ok := s.entries[w.Key]; ok {
+		old := el.Value.(*Write)
+		if !old.TS.Before(w.TS) {
+			return false
+		}
+		s.bytes += e.size() - old.size()
+		el.Value = e
+		s.lru.MoveToFront(el)
+	} else {
+		s.entries[w.Key] = s.lru.PushFront(e)
+		s.bytes += e.size()
+	}
+	s.evict()
+	return true
+}
+
+// evict drops the least recently used entries until s is within its
+// limits.
+func (s *Store) evict() {
+	for s.limits.MaxKeys > 0 && s.lru.Len() > s.limits.MaxKeys ||
+		s.limits.MaxBytes > 0 && s.bytes > s.limits.MaxBytes {
+		el := s.lru.Back()
+		e := el.Value.(*Write)
+		s.lru.Remove(el)
+		delete(s.entries, e.Key)
+		s.bytes -= e.size()
+	}
+}
+
+func writeRecord(out io.Writer, w *Write) error {
+	rec := record{Key: w.Key, Value: w.Value, Time: w.TS.Time, Node: w.TS.Node, Deleted: w.Deleted}
+	if !w.Expires.IsZero() {
+		rec.Expires = w.Expires.UnixNano()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// Len is how many keys there are, counting deleted and expired ones that
+// haven't been evicted yet.
+func (s *Store) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lru.Len()
+}
+
+// Bytes is the total size of the keys and values.
+func (s *Store) Bytes() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.bytes
+}
+
+// LastTime is the latest timestamp the store has seen.
+func (s *Store) LastTime() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lastTime
+}
+
+// Snapshot writes every entry that hasn't expired to a new snapshot file,
+// least recently used first so restoring keeps the order, and then empties
+// the log. The new
+// snapshot replaces the old one atomically, so a crash leaves either the old
+// snapshot and log or the new snapshot; replaying the old log on top of the
+// new snapshot changes nothing.
+func (s *Store) Snapshot() error {
+	if s.dir == "" {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tmp, err := os.CreateTemp(s.dir, SNAPSHOT_FILE+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	now := s.now()
+	for el := s.lru.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*Write)
+		if !e.Deleted && !e.visible(now) {
+			// expired
+			continue
+		}
+		if err := writeRecord(w, e); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, SNAPSHOT_FILE)); err != nil {
+		return err
+	}
+	return s.log.Truncate(0)
+}
+
+// Close snapshots the store and closes its log.
+func (s *Store) Close() error {
+	if s.dir == "" {
+		return nil
+	}
+	err := s.Snapshot()
+	if cerr := s.log.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func at(time uint64) Timestamp {
+	return Timestamp{Time: time, Node: "a"}
+}
+
+func TestStoreEviction(t *testing.T) {
+	t.Parallel()
+	s := NewStore(Limits{MaxKeys: 3})
+	s.Put("a", "1", at(1))
+	s.Put("b", "2", at(2))
+	s.Put("c", "3", at(3))
+	// a is now more recent than b
+	s.Get("a")
+	s.Put("d", "4", at(4))
+	if _, ok := s.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := s.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	s = NewStore(Limits{MaxBytes: 10})
+	s.Put("k1", "aaaa", at(1))
+	s.Put("k2", "bbbb", at(2))
+	if s.Len() != 1 || s.Bytes() != 6 {
+		t.Errorf("got %d keys, %d bytes", s.Len(), s.Bytes())
+	}
+	// replacing a value counts the difference
+	s.Put("k2", "b", at(3))
+	if s.Bytes() != 3 {
+		t.Errorf("got %d bytes", s.Bytes())
+	}
+	if s.Put("big", "this is far too big", at(4)) {
+		t.Error("stored an entry bigger than the limit")
+	}
+	if s.Put(VERSION_KEY, "2.0", at(5)) {
+		t.Error("stored version")
+	}
+	if _, ok := s.Get("k2"); !ok {
+		t.Error("k2 evicted by entries that weren't stored")
+	}
+}
+
+func TestStorePersistence(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	s, err := OpenStore(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("a", "1", at(1))
+	s.Put("b", "2", at(2))
+	if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.Put("a", "3", at(3))
+	s.Put("c", "4", Timestamp{7, "b"})
+	// a crash in the middle of an append
+	s.log.WriteString(`{"key":"d","val`)
+	s.log.Close()
+
+	s, err = OpenStore(dir, Limits{MaxKeys: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	want := map[string]string{"a": "3", "c": "4"}
+	for key, value := range want {
+		if got, _ := s.Get(key); got != value {
+			t.Errorf("%s: got %q, want %q", key, got, value)
+		}
+	}
+	// b is the least recently used, so it doesn't fit any more
+	if _, ok := s.Get("b"); ok || s.Len() != 2 {
+		t.Errorf("expected only a and c, got %d keys", s.Len())
+	}
+
+	// the node carries on after the latest insert
+	n := newNode("a", nil, nil, s)
+	n.handleRequest([]byte("c=5"), &net.IPAddr{IP: net.ParseIP("0.0.0.0")})
+	if got, _ := s.Get("c"); got != "5" {
+		t.Errorf("local insert lost to a restored one: got %q", got)
+	}
+}
+
+func TestStoreTornLog(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	s, err := OpenStore(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("a", "1", at(1))
+	// a crash in the middle of an append
+	s.log.WriteString(`{"key":"d","val`)
+	s.log.Close()
+
+	s, err = OpenStore(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("b", "2", at(2))
+	// and another crash, this time with nothing half written
+	s.log.Close()
+
+	s, err = OpenStore(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for key, value := range map[string]string{"a": "1", "b": "2"} {
+		if got, _ := s.Get(key); got != value {
+			t.Errorf("%s: got %q, want %q", key, got, value)
+		}
+	}
+	if _, ok := s.Get("d"); ok || s.Len() != 2 {
+		t.Errorf("expected only a and b, got %d keys", s.Len())
+	}
+	data, err := os.ReadFile(filepath.Join(dir, LOG_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(string(data), "\n"); len(lines) != 3 || lines[2] != "" {
+		t.Errorf("expected two complete lines in the log, got %q", data)
+	}
+}
+
+func TestStoreBadFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, SNAPSHOT_FILE), []byte("not json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStore(dir, Limits{}); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestStoreConcurrent(t *testing.T) {
+	t.Parallel()
+	s := NewStore(Limits{MaxKeys: 50})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := fmt.Sprintf("k%d", j%100)
+				s.Put(key, "v", Timestamp{Time: uint64(j), Node: fmt.Sprint(i)})
+				s.Get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if s.Len() != 50 {
+		t.Errorf("got %d keys", s.Len())
+	}
+}
+
+func TestStorePersistsDeletesAndExpiry(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	s, err := OpenStore(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.Apply(Write{Key: "gone", Value: "1", TS: at(1)})
+	s.Apply(Write{Key: "gone", TS: at(2), Deleted: true})
+	s.Apply(Write{Key: "later", Value: "2", TS: at(3), Expires: now.Add(time.Hour)})
+	s.Apply(Write{Key: "expired", Value: "3", TS: at(4), Expires: now.Add(-time.Second)})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenStore(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if keys := s.Keys(""); len(keys) != 1 || keys[0] != "later" {
+		t.Errorf("got keys %v", keys)
+	}
+	// the delete is remembered, so an older insert can't undo it
+	if s.Put("gone", "old", at(1)) {
+		t.Error("older insert beat a restored delete")
+	}
+	if s.Len() != 2 {
+		t.Errorf("expected the delete and later, got %d entries", s.Len())
+	}
+}
@@ -0,0 +1,93 @@
+// transcript reads the transcripts written by the proxy's -record flag.
+//
+//	transcript show [-changed] session.jsonl
+//	transcript diff a.jsonl b.jsonl
+//
+// show prints each message with what the proxy sent on in its place, or
+// with -changed only the messages that were rewritten or dropped. diff lines
+// up two sessions message by message, ignoring timing, and marks messages
+// only in the first with - and only in the second with +.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"z10f.com/golang/protohackers/05/proxy"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: transcript show [-changed] session.jsonl")
+	fmt.Fprintln(os.Stderr, "       transcript diff a.jsonl b.jsonl")
+	os.Exit(2)
+}
+
+func readTranscriptFile(name string) ([]proxy.TranscriptRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return proxy.ReadTranscript(f)
+}
+
+func show(args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	changed := fs.Bool("changed", false, "only show messages the proxy rewrote or dropped")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	records, err := readTranscriptFile(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range records {
+		if *changed && !r.Changed() {
+			continue
+		}
+		fmt.Println(r)
+	}
+}
+
+func diff(args []string) {
+	if len(args) != 2 {
+		usage()
+	}
+	a, err := readTranscriptFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := readTranscriptFile(args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	same := true
+	fmt.Println("---", args[0])
+	fmt.Println("+++", args[1])
+	for _, line := range proxy.Diff(a, b) {
+		if line.Op != proxy.DiffSame {
+			same = false
+		}
+		fmt.Println(line)
+	}
+	if !same {
+		os.Exit(1)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "show":
+		show(os.Args[2:])
+	case "diff":
+		diff(os.Args[2:])
+	default:
+		usage()
+	}
+}
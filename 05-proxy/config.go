@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"z10f.com/golang/protohackers/05/proxy"
+)
+
+// Rule replaces every match of Pattern, a Go regexp, with Replace, taken
+// literally, in lines going in Direction. If Token is set, Pattern has to
+// match a whole space-separated token.
+type Rule struct {
+	Pattern   string          `json:"pattern"`
+	Replace   string          `json:"replace"`
+	Direction proxy.Direction `json:"direction"`
+	Token     bool            `json:"token,omitempty"`
+	re        Matcher
+}
+
+// Framing says how to split up the streams. Rules only work with lines.
+type Framing struct {
+	// Type is "lines" (the default), "length" or "raw".
+	Type string `json:"type"`
+	// Offset, Size and Inclusive describe the length field for "length";
+	// see proxy.LengthPrefixed.
+	Offset    int  `json:"offset,omitempty"`
+	Size      int  `json:"size,omitempty"`
+	Inclusive bool `json:"inclusive,omitempty"`
+}
+
+var ErrBadFraming = errors.New("bad framing")
+
+func (f Framing) Framer() (proxy.Framer, error) {
+	switch f.Type {
+	case "", "lines":
+		return proxy.Lines{}, nil
+	case "length":
+		switch f.Size {
+		case 1, 2, 4, 8:
+		default:
+			return nil, fmt.Errorf("%w: length size %d", ErrBadFraming, f.Size)
+		}
+		return proxy.LengthPrefixed{Offset: f.Offset, Size: f.Size, Inclusive: f.Inclusive}, nil
+	case "raw":
+		return proxy.Raw{}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown type %q", ErrBadFraming, f.Type)
+}
+
+// Duration is a time.Duration written like "5s" in config files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Retry is proxy.Retry for config files.
+type Retry struct {
+	Attempts   int      `json:"attempts"`
+	Backoff    Duration `json:"backoff"`
+	MaxBackoff Duration `json:"max_backoff"`
+}
+
+type Config struct {
+	Listen   string `json:"listen"`
+	Upstream string `json:"upstream"`
+	// Upstreams, if set, are used instead of Upstream, in order of
+	// preference; see proxy.Upstreams.
+	Upstreams       []string `json:"upstreams,omitempty"`
+	DialTimeout     Duration `json:"dial_timeout"`
+	GreetingTimeout Duration `json:"greeting_timeout"`
+	Retry           Retry    `json:"retry"`
+	// HealthCheck is how often to check on the upstreams in the
+	// background. 0 means they're only checked when dialed.
+	HealthCheck Duration `json:"health_check,omitempty"`
+	// Unavailable is the line sent to clients when no upstream can be
+	// reached. Nothing is sent if it's empty.
+	Unavailable string  `json:"unavailable"`
+	Framing     Framing `json:"framing"`
+	Rules       []*Rule `json:"rules"`
+	// Record, if set, is a directory to write a transcript of each
+	// connection to.
+	Record string `json:"record,omitempty"`
+	framer proxy.Framer
+
+	upstreamsOnce sync.Once
+	upstreams     *proxy.Upstreams
+}
+
+// DefaultConfig is the Mob in the Middle challenge: send everyone's
+// Boguscoins to Tony.
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:   ":1337",
+		Upstream: "chat.protohackers.com:16963",
+		// the chat server greets new users straight away
+		DialTimeout:     Duration(5 * time.Second),
+		GreetingTimeout: Duration(5 * time.Second),
+		Retry: Retry{
+			Attempts:   3,
+			Backoff:    Duration(100 * time.Millisecond),
+			MaxBackoff: Duration(2 * time.Second),
+		},
+		Unavailable: UNAVAILABLE,
+		Framing:     Framing{Type: "lines"},
+		framer:      proxy.Lines{},
+		Rules: []*Rule{{
+			Pattern:   COIN_PATTERN,
+			Replace:   ADDRESS,
+			Direction: proxy.Both,
+			Token:     true,
+			re:        coinMatcher,
+		}},
+	}
+}
+
+// LoadConfig reads a JSON config file. Anything it leaves out is taken from
+// DefaultConfig, except that a file with a "rules" list replaces the default
+// rules entirely, so a file with framing other than lines needs "rules": [].
+// Rules without a direction apply both ways. Files with framing other than
+// lines get no greeting timeout or unavailable line unless they ask for
+// them, since those are for the chat server.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file Config
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg := DefaultConfig()
+	if file.Listen != "" {
+		cfg.Listen = file.Listen
+	}
+	if file.Upstream != "" {
+		cfg.Upstream = file.Upstream
+	}
+	cfg.Upstreams = file.Upstreams
+	if file.Framing.Type != "" {
+		cfg.Framing = file.Framing
+		cfg.framer = nil
+		if file.Framing.Type != "lines" {
+			cfg.GreetingTimeout = 0
+			cfg.Unavailable = ""
+		}
+	}
+	if file.DialTimeout != 0 {
+		cfg.DialTimeout = file.DialTimeout
+	}
+	if file.GreetingTimeout != 0 {
+		cfg.GreetingTimeout = file.GreetingTimeout
+	}
+	if file.Retry.Attempts != 0 {
+		cfg.Retry.Attempts = file.Retry.Attempts
+	}
+	if file.Retry.Backoff != 0 {
+		cfg.Retry.Backoff = file.Retry.Backoff
+	}
+	if file.Retry.MaxBackoff != 0 {
+		cfg.Retry.MaxBackoff = file.Retry.MaxBackoff
+	}
+	cfg.HealthCheck = file.HealthCheck
+	if file.Unavailable != "" {
+		cfg.Unavailable = file.Unavailable
+	}
+	if file.Rules != nil {
+		cfg.Rules = file.Rules
+	}
+	cfg.Record = file.Record
+	if err := cfg.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) compile() error {
+	if c.framer == nil {
+		framer, err := c.Framing.Framer()
+		if err != nil {
+			return err
+		}
+		c.framer = framer
+	}
+	if _, ok := c.framer.(proxy.Lines); !ok && len(c.Rules) > 0 {
+		return fmt.Errorf("%w: rules need line framing", ErrBadFraming)
+	}
+	for i, rule := range c.Rules {
+		if rule.re != nil {
+			continue
+		}
+		if rule.Token {
+			re, err := regexp.Compile(`^(?:` + rule.Pattern + `)$`)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i, err)
+			}
+			rule.re = TokenMatcher{Match: re.MatchString}
+		} else {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i, err)
+			}
+			rule.re = re
+		}
+		if rule.Direction == 0 {
+			rule.Direction = proxy.Both
+		}
+	}
+	return nil
+}
+
+// Rewrite applies every rule for dir to line, in order.
+func (c *Config) Rewrite(line string, dir proxy.Direction) string {
+	for _, rule := range c.Rules {
+		if rule.Direction&dir != 0 {
+			line = rule.re.ReplaceAllLiteralString(line, rule.Replace)
+		}
+	}
+	return line
+}
+
+// Dialer is the proxy.Upstreams the config describes. It's made once, so
+// that every connection sees which upstreams are down.
+func (c *Config) Dialer() *proxy.Upstreams {
+	c.upstreamsOnce.Do(func() {
+		addrs := c.Upstreams
+		if len(addrs) == 0 {
+			addrs = []string{c.Upstream}
+		}
+		c.upstreams = &proxy.Upstreams{
+			Addrs:           addrs,
+			DialTimeout:     time.Duration(c.DialTimeout),
+			GreetingTimeout: time.Duration(c.GreetingTimeout),
+			Retry: proxy.Retry{
+				Attempts:   c.Retry.Attempts,
+				Backoff:    time.Duration(c.Retry.Backoff),
+				MaxBackoff: time.Duration(c.Retry.MaxBackoff),
+			},
+		}
+	})
+	return c.upstreams
+}
+
+// Proxy makes the proxy.Proxy the config describes.
+func (c *Config) Proxy() *proxy.Proxy {
+	p := &proxy.Proxy{Framer: c.framer}
+	if len(c.Rules) > 0 {
+		p.Transform = proxy.LineTransform(func(dir proxy.Direction, line string) string {
+			return c.Rewrite(line, dir)
+		})
+	}
+	return p
+}
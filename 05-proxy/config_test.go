@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"z10f.com/golang/protohackers/05/proxy"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "proxy.json")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `{
+		"upstream": "localhost:9999",
+		"rules": [
+			{"pattern": "cat", "replace": "dog", "direction": "client-to-server"},
+			{"pattern": "red", "replace": "blue", "direction": "server-to-client"},
+			{"pattern": "[0-9]+", "replace": "N"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != DefaultConfig().Listen || cfg.Upstream != "localhost:9999" {
+		t.Errorf("got listen %q, upstream %q", cfg.Listen, cfg.Upstream)
+	}
+	cases := []struct {
+		line string
+		dir  proxy.Direction
+		want string
+	}{
+		{"a red cat 42", proxy.ToServer, "a red dog N"},
+		{"a red cat 42", proxy.ToClient, "a blue cat N"},
+		{"nothing to see", proxy.ToServer, "nothing to see"},
+	}
+	for _, c := range cases {
+		if got := cfg.Rewrite(c.line, c.dir); got != c.want {
+			t.Errorf("Rewrite(%q, %v) = %q, want %q", c.line, c.dir, got, c.want)
+		}
+	}
+
+	// the defaults are the challenge's
+	cfg, err = LoadConfig(writeConfig(t, `{"listen": ":2000"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Rewrite("pay "+testAddresses[0], proxy.ToServer); got != "pay "+ADDRESS {
+		t.Errorf("default rule not applied: %q", got)
+	}
+
+	// binary protocols can go through, but without rules
+	cfg, err = LoadConfig(writeConfig(t, `{"framing": {"type": "length", "offset": 1, "size": 4, "inclusive": true}, "rules": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Proxy(); got.Framer != (proxy.LengthPrefixed{Offset: 1, Size: 4, Inclusive: true}) || got.Transform != nil {
+		t.Errorf("got %#v", got)
+	}
+
+	// durations, and several upstreams
+	cfg, err = LoadConfig(writeConfig(t, `{
+		"upstreams": ["a:1", "b:2"],
+		"dial_timeout": "2s",
+		"retry": {"attempts": 5, "backoff": "10ms"},
+		"health_check": "1m"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := cfg.Dialer()
+	if len(u.Addrs) != 2 || u.Addrs[1] != "b:2" {
+		t.Errorf("got upstreams %v", u.Addrs)
+	}
+	want := proxy.Retry{Attempts: 5, Backoff: 10 * time.Millisecond, MaxBackoff: 2 * time.Second}
+	if u.DialTimeout != 2*time.Second || u.GreetingTimeout != 5*time.Second || u.Retry != want {
+		t.Errorf("got %+v", u)
+	}
+	if time.Duration(cfg.HealthCheck) != time.Minute || cfg.Unavailable != UNAVAILABLE {
+		t.Errorf("got health check %v, unavailable %q", cfg.HealthCheck, cfg.Unavailable)
+	}
+
+	bad := []string{
+		`{"rules": [{"pattern": "x", "replace": "y", "direction": "up"}]}`,
+		`{"rules": [{"pattern": "(", "replace": "y"}]}`,
+		`not json`,
+		`{"framing": {"type": "raw"}}`,
+		`{"framing": {"type": "length", "size": 3}, "rules": []}`,
+		`{"framing": {"type": "morse"}, "rules": []}`,
+		`{"dial_timeout": 5}`,
+		`{"dial_timeout": "soon"}`,
+	}
+	for _, contents := range bad {
+		if _, err := LoadConfig(writeConfig(t, contents)); err == nil {
+			t.Errorf("expected an error loading %s", contents)
+		}
+	}
+}
+
+// startUpstream runs a stand-in server that greets each client and then
+// echoes its lines back with a prefix.
+func startUpstream(t *testing.T, greeting string) (addr string, received chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	received = make(chan string, 100)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.WriteString(conn, greeting+"\n")
+				sc := bufio.NewScanner(conn)
+				for sc.Scan() {
+					received <- sc.Text()
+					io.WriteString(conn, "echo: "+sc.Text()+"\n")
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestProxy(t *testing.T) {
+	addr, received := startUpstream(t, "Welcome, red rover")
+	cfg := &Config{
+		Upstream: addr,
+		Rules: []*Rule{
+			{Pattern: "cat", Replace: "dog", Direction: proxy.ToServer},
+			{Pattern: "red", Replace: "blue", Direction: proxy.ToClient},
+		},
+		Record: t.TempDir(),
+	}
+	if err := cfg.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go handleConnection(server, cfg)
+	sc := bufio.NewScanner(client)
+	expect := func(want string) {
+		t.Helper()
+		if !sc.Scan() {
+			t.Fatalf("waiting for %q: %v", want, sc.Err())
+		}
+		if sc.Text() != want {
+			t.Fatalf("expected %q, got %q", want, sc.Text())
+		}
+	}
+	expect("Welcome, blue rover")
+	io.WriteString(client, "my red cat\n")
+	if got := <-received; got != "my red dog" {
+		t.Errorf("upstream got %q", got)
+	}
+	expect("echo: my blue dog")
+
+	io.WriteString(client, strings.Repeat("cat ", 3)+"\n")
+	expect("echo: dog dog dog ")
+
+	// every message is recorded before it's passed on
+	files, err := filepath.Glob(filepath.Join(cfg.Record, "*.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one transcript, got %v, %v", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := proxy.ReadTranscript(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("expected 5 records, got %v", records)
+	}
+	if r := records[1]; r.Direction != proxy.ToServer || string(r.Original) != "my red cat\n" || string(r.Rewritten) != "my red dog\n" {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	cfg := &Config{
+		Upstream:    l.Addr().String(),
+		Unavailable: "* No chat for you",
+	}
+	if err := cfg.compile(); err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	go handleConnection(server, cfg)
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "* No chat for you\n" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -1,14 +1,3 @@
 module z10f.com/golang/protohackers/05
 
 go 1.19
-
-require (
-	github.com/google/uuid v1.3.0 // indirect
-	github.com/mattn/go-isatty v0.0.12 // indirect
-	github.com/remyoudompheng/bigfft v0.0.0-20200410134404-eec4a21b6bb0 // indirect
-	go.arsenm.dev/pcre v0.0.0-20220530205550-74594f6c8b0e // indirect
-	golang.org/x/sys v0.0.0-20211007075335-d3039528d8ac // indirect
-	modernc.org/libc v1.16.8 // indirect
-	modernc.org/mathutil v1.4.1 // indirect
-	modernc.org/memory v1.1.1 // indirect
-)
@@ -1,127 +1,106 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
-	"go.arsenm.dev/pcre"
+	"z10f.com/golang/protohackers/05/proxy"
 )
 
-// dropCR drops a terminal \r from the data.
-func dropCR(data []byte) []byte {
-	if len(data) > 0 && data[len(data)-1] == '\r' {
-		return data[0 : len(data)-1]
-	}
-	return data
-}
-
-// ScanLines is a split function for a Scanner that returns each line of
-// text, stripped of any trailing end-of-line marker. The returned line may
-// be empty. The end-of-line marker is one optional carriage return followed
-// by one mandatory newline. In regular expression notation, it is `\r?\n`.
-// The last non-empty line of input will be returned even if it has no
-// newline.
-func ScanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	if atEOF && len(data) == 0 {
-		return 0, nil, nil
-	}
-	if i := bytes.IndexByte(data, '\n'); i >= 0 {
-		// We have a full newline-terminated line.
-		return i + 1, dropCR(data[0:i]), nil
-	}
-	// If we're at EOF, we have a final, non-terminated line. Return it.
-	if atEOF {
-		return 0, nil, fmt.Errorf("bad")
-	}
-	// Request more data.
-	return 0, nil, nil
-}
-
 //const TIMEOUT_SECONDS = 5
 //const MAX_REQUESTS = 1_000_000
 //const MSG_LEN = 9
 //conn.SetReadDeadline(time.Now().Add(TIMEOUT_SECONDS * time.Second))
 // log.Println("Handling request from", conn.RemoteAddr())
 
-// var coinRe = pcre.MustCompile(`\b7[A-Za-z0-9]{25,34}\b`)
-var coinRe = pcre.MustCompile(`(?<![^ ])7[A-Za-z0-9]{25,34}(?![^ ])`)
+// COIN_PATTERN is what isBoguscoin checks, for configs. Addresses have to be
+// whole tokens.
+const COIN_PATTERN = `7[A-Za-z0-9]{25,34}`
 
-//var coinRe = regexp.MustCompile(`\b7[A-Za-z0-9]{25,35}\b`)
+var coinMatcher = TokenMatcher{Match: isBoguscoin}
 
 func mangleMessage(msg, address string) string {
-	return coinRe.ReplaceAllLiteralString(msg, address)
+	return coinMatcher.ReplaceAllLiteralString(msg, address)
 }
 
 const ADDRESS = "7YWHMfk9JZe0LM0g1ZauHuiSxhI"
 
-func proxyOneWay(in, out net.Conn, scanner bufio.Scanner) {
-	for scanner.Scan() {
-		msg := scanner.Text()
-		log.Printf("Got message [%s %s] %s\n", in.RemoteAddr(), out.RemoteAddr(), msg)
-		_, err := io.WriteString(out, mangleMessage(msg, ADDRESS)+"\n")
-		if err != nil {
-			log.Printf("Error writing %s\n", err)
-			break
-		}
-	}
-	in.Close()
-	out.Close()
+const UNAVAILABLE = "* The chat server is unavailable, please try again later."
+
+// UNAVAILABLE_TIMEOUT bounds how long we try to tell a client there's no
+// upstream.
+const UNAVAILABLE_TIMEOUT = 5 * time.Second
+
+// createTranscript makes a new file in dir for a connection's transcript,
+// named for when it started and who it's from.
+func createTranscript(dir string, addr net.Addr) (*os.File, error) {
+	name := time.Now().Format("20060102-150405.000000") + "-" +
+		strings.NewReplacer(":", "_", "/", "_", "[", "", "]", "").Replace(addr.String()) +
+		".jsonl"
+	return os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 }
 
-func handleConnection(conn net.Conn) {
+func handleConnection(conn net.Conn, cfg *Config) {
 	log.Println("Accepted connection from", conn.RemoteAddr())
-	upstream, err := net.Dial("tcp", "chat.protohackers.com:16963")
+	upstream, err := cfg.Dialer().Dial()
 	if err != nil {
+		log.Println("Failed to connect to upstream for", conn.RemoteAddr(), err)
+		if cfg.Unavailable != "" {
+			conn.SetWriteDeadline(time.Now().Add(UNAVAILABLE_TIMEOUT))
+			io.WriteString(conn, cfg.Unavailable+"\n")
+		}
 		conn.Close()
 		return
 	}
-
-	upstreamScanner := bufio.NewScanner(upstream)
-	upstreamScanner.Split(ScanLines)
-	if !upstreamScanner.Scan() {
-		log.Println("Failed to read greeting from upstream")
-		conn.Close()
-		upstream.Close()
-		return
+	p := cfg.Proxy()
+	if cfg.Record != "" {
+		f, err := createTranscript(cfg.Record, conn.RemoteAddr())
+		if err != nil {
+			log.Println("Not recording:", err)
+		} else {
+			defer f.Close()
+			p.Recorder = proxy.NewRecorder(f)
+		}
 	}
-
-	_, err = io.WriteString(conn, upstreamScanner.Text()+"\n")
-	if err != nil {
-		log.Println("Failed to write greeting to client")
-		conn.Close()
-		upstream.Close()
-		return
+	if err := p.Pipe(conn, upstream); err != nil {
+		log.Println("Proxying for", conn.RemoteAddr(), "failed:", err)
 	}
+	log.Println("Done with", conn.RemoteAddr())
+}
 
-	log.Println("Reading name for", conn.RemoteAddr())
-	scanner := bufio.NewScanner(conn)
-	scanner.Split(ScanLines)
-	if !scanner.Scan() {
-		log.Println("Failed to read name")
-		conn.Close()
-		upstream.Close()
-		return
+func main() {
+	configPath := flag.String("config", "", "JSON file with the listen address, upstream and rewrite rules")
+	record := flag.String("record", "", "directory to write a JSON lines transcript of each connection to")
+	flag.Parse()
+	cfg := DefaultConfig()
+	if *configPath != "" {
+		var err error
+		cfg, err = LoadConfig(*configPath)
+		if err != nil {
+			log.Fatal("could not load config: ", err)
+		}
 	}
-	name := scanner.Text()
-	log.Println("Got name", name, "from", conn.RemoteAddr())
-	_, err = io.WriteString(upstream, name+"\n")
-	if err != nil {
-		log.Println("Failed to write name to upstream")
-		conn.Close()
-		upstream.Close()
-		return
+	if *record != "" {
+		cfg.Record = *record
+	}
+	if cfg.Record != "" {
+		if err := os.MkdirAll(cfg.Record, 0755); err != nil {
+			log.Fatal("could not make transcript directory: ", err)
+		}
 	}
-	log.Println("kicking off goroutines for ", conn.RemoteAddr())
-	go proxyOneWay(upstream, conn, *upstreamScanner)
-	go proxyOneWay(conn, upstream, *scanner)
-}
 
-func main() {
-	l, err := net.Listen("tcp", ":1337")
+	if cfg.HealthCheck > 0 {
+		go cfg.Dialer().Watch(time.Duration(cfg.HealthCheck), nil)
+	}
+
+	l, err := net.Listen("tcp", cfg.Listen)
 	if err != nil {
 		log.Fatal("could not listen", err)
 	}
@@ -131,7 +110,8 @@ func main() {
 		conn, err := l.Accept()
 		if err != nil {
 			log.Println("Error accepting", err)
+			continue
 		}
-		go handleConnection(conn)
+		go handleConnection(conn, cfg)
 	}
 }
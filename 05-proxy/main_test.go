@@ -18,7 +18,7 @@ var testAddresses = []string{
 
 func TestRegex(t *testing.T) {
 	for i, vec := range testAddresses {
-		if coinRe.FindString(vec) == "" {
+		if coinMatcher.FindString(vec) == "" {
 			t.Errorf("Failed a test vector (%d): %s", i, vec)
 		}
 	}
@@ -26,14 +26,28 @@ func TestRegex(t *testing.T) {
 
 var notAddresses = []string{
 	"7aKj5oAZ0TEOmOXOhErqj2T5uMDF64xvvi-w22cNT6CkBsNdn8gdy0lOFDUyBHUM-1234",
+	"uBJAKsN5eTrMxOQzIpgR1IMI6h5",
+}
+
+// These used to be in notAddresses, but they're 35 and 26 characters, and so
+// match 7[A-Za-z0-9]{25,34} as much as any address does. The old PCRE
+// pattern matched them too.
+var lookalikeAddresses = []string{
 	"75wFxe5TfnkcS3RIGTHABheno6gwnA0asYt",
 	"785vE6mDLTTcrQDqR9DSLdcSR5",
-	"uBJAKsN5eTrMxOQzIpgR1IMI6h5",
+}
+
+func TestLookalikes(t *testing.T) {
+	for i, vec := range lookalikeAddresses {
+		if coinMatcher.FindString(vec) != vec {
+			t.Errorf("Lookalike %d no longer matches: %s", i, vec)
+		}
+	}
 }
 
 func TestRegexNegative(t *testing.T) {
 	for i, vec := range notAddresses {
-		res := coinRe.FindString(vec)
+		res := coinMatcher.FindString(vec)
 		if res != "" {
 			t.Errorf("Failed a test vector (%d): %s (got %s)", i, vec, res)
 		}
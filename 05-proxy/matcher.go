@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+)
+
+// Matcher finds text to rewrite in a line. *regexp.Regexp is one.
+type Matcher interface {
+	FindString(s string) string
+	ReplaceAllLiteralString(s, repl string) string
+}
+
+// TokenMatcher matches whole space-separated tokens that Match accepts. It's
+// what a pattern wrapped in the lookarounds (?<![^ ])...(?![^ ]) would match,
+// without needing a regex engine that has them.
+type TokenMatcher struct {
+	Match func(token string) bool
+}
+
+func (m TokenMatcher) FindString(s string) string {
+	for _, token := range strings.Split(s, " ") {
+		if m.Match(token) {
+			return token
+		}
+	}
+	return ""
+}
+
+func (m TokenMatcher) ReplaceAllLiteralString(s, repl string) string {
+	tokens := strings.Split(s, " ")
+	changed := false
+	for i, token := range tokens {
+		if m.Match(token) {
+			tokens[i] = repl
+			changed = true
+		}
+	}
+	if !changed {
+		return s
+	}
+	return strings.Join(tokens, " ")
+}
+
+// isBoguscoin reports whether token is a Boguscoin address, which is
+// 7[A-Za-z0-9]{25,34}.
+func isBoguscoin(token string) bool {
+	if len(token) < 26 || len(token) > 35 || token[0] != '7' {
+		return false
+	}
+	for i := 1; i < len(token); i++ {
+		c := token[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTokenMatcher(t *testing.T) {
+	addr := testAddresses[0]
+	cases := []struct {
+		line string
+		want string
+	}{
+		{addr, "X"},
+		{" " + addr + " ", " X "},
+		{addr + "  " + addr, "X  X"},
+		{"[" + addr + "]", "[" + addr + "]"},
+		{addr + "!", addr + "!"},
+		{"pay " + addr + "\t", "pay " + addr + "\t"},
+		{"", ""},
+		{"no addresses here", "no addresses here"},
+	}
+	for _, c := range cases {
+		if got := coinMatcher.ReplaceAllLiteralString(c.line, "X"); got != c.want {
+			t.Errorf("ReplaceAllLiteralString(%q) = %q, want %q", c.line, got, c.want)
+		}
+	}
+}
+
+// tokenRe is the token matcher done with a regexp, to check and benchmark
+// isBoguscoin against.
+var tokenRe = regexp.MustCompile(`^` + COIN_PATTERN + `$`)
+
+func TestIsBoguscoin(t *testing.T) {
+	candidates := append([]string{}, testAddresses...)
+	candidates = append(candidates, notAddresses...)
+	candidates = append(candidates, lookalikeAddresses...)
+	candidates = append(candidates, "", "7", strings.Repeat("7", 25), strings.Repeat("7", 26),
+		strings.Repeat("7", 35), strings.Repeat("7", 36), "8"+strings.Repeat("a", 30),
+		"7"+strings.Repeat("a", 29)+"_", "7"+strings.Repeat("é", 15))
+	for _, c := range candidates {
+		if got, want := isBoguscoin(c), tokenRe.MatchString(c); got != want {
+			t.Errorf("isBoguscoin(%q) = %v, want %v", c, got, want)
+		}
+	}
+}
+
+var benchLine = "Please send the payment of 750 Boguscoins to " + testAddresses[3] + " , or " + testAddresses[5] + " if that's easier"
+
+func BenchmarkMangle(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		mangleMessage(benchLine, ADDRESS)
+	}
+}
+
+func BenchmarkMangleNoMatch(b *testing.B) {
+	line := "Hi everyone, how is it going? Nothing to see here, just chatting."
+	for i := 0; i < b.N; i++ {
+		mangleMessage(line, ADDRESS)
+	}
+}
+
+func BenchmarkMangleRegexp(b *testing.B) {
+	m := TokenMatcher{Match: tokenRe.MatchString}
+	for i := 0; i < b.N; i++ {
+		m.ReplaceAllLiteralString(benchLine, ADDRESS)
+	}
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxMessage bounds messages from framers that don't set their own
+// limit.
+const DefaultMaxMessage = 1 << 20
+
+var (
+	ErrTooLong   = errors.New("message too long")
+	ErrBadLength = errors.New("bad length field")
+)
+
+// A Framer splits a byte stream into messages. Writing the messages back out
+// in order, unchanged, gives the same stream.
+type Framer interface {
+	// ReadMessage returns the next message, or io.EOF once the stream
+	// ends cleanly between messages.
+	ReadMessage(r *bufio.Reader) ([]byte, error)
+}
+
+// Lines frames newline-terminated lines. Messages include their newline, so
+// a last line without one is passed on as it is; see LineTransform.
+type Lines struct {
+	// Max is the longest line, newline included. 0 means
+	// DefaultMaxMessage.
+	Max int
+}
+
+func (l Lines) ReadMessage(r *bufio.Reader) ([]byte, error) {
+	max := l.Max
+	if max == 0 {
+		max = DefaultMaxMessage
+	}
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > max {
+			return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLong, max)
+		}
+		line = append(line, chunk...)
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(line) > 0:
+			// the next call gets the EOF
+			return line, nil
+		case err != nil:
+			return nil, err
+		}
+		return line, nil
+	}
+}
+
+// LengthPrefixed frames messages that carry their length in a big-endian
+// field, like Pest Control's: a type byte, then a 4-byte length that counts
+// the whole message (LengthPrefixed{Offset: 1, Size: 4, Inclusive: true}).
+type LengthPrefixed struct {
+	// Offset is how many bytes come before the length field.
+	Offset int
+	// Size is the length field's size: 1, 2, 4 or 8.
+	Size int
+	// Inclusive is set if the length counts the whole message, and not
+	// just what comes after the length field.
+	Inclusive bool
+	// Max is the longest message. 0 means DefaultMaxMessage.
+	Max int
+}
+
+func (l LengthPrefixed) ReadMessage(r *bufio.Reader) ([]byte, error) {
+	max := l.Max
+	if max == 0 {
+		max = DefaultMaxMessage
+	}
+	header := make([]byte, l.Offset+l.Size)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	field := header[l.Offset:]
+	var length uint64
+	switch l.Size {
+	case 1:
+		length = uint64(field[0])
+	case 2:
+		length = uint64(binary.BigEndian.Uint16(field))
+	case 4:
+		length = uint64(binary.BigEndian.Uint32(field))
+	case 8:
+		length = binary.BigEndian.Uint64(field)
+	default:
+		return nil, fmt.Errorf("%w: can't read a %d-byte length", ErrBadLength, l.Size)
+	}
+	total := length
+	if !l.Inclusive {
+		total += uint64(len(header))
+	}
+	if total < uint64(len(header)) {
+		return nil, fmt.Errorf("%w: %d is shorter than the header", ErrBadLength, length)
+	}
+	if total > uint64(max) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLong, total)
+	}
+	msg := make([]byte, total)
+	copy(msg, header)
+	if _, err := io.ReadFull(r, msg[len(header):]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Raw passes on whatever has arrived, in chunks of at most Size bytes, for
+// protocols there's no need to understand.
+type Raw struct {
+	// Size is the largest chunk. 0 means 4096.
+	Size int
+}
+
+func (c Raw) ReadMessage(r *bufio.Reader) ([]byte, error) {
+	size := c.Size
+	if size == 0 {
+		size = 4096
+	}
+	buf := make([]byte, size)
+	n, err := r.Read(buf)
+	if n > 0 {
+		return buf[:n], nil
+	}
+	return nil, err
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// readAll frames input and returns the messages and the error that ended
+// them.
+func readAll(f Framer, input []byte) ([]string, error) {
+	r := bufio.NewReaderSize(bytes.NewReader(input), 16)
+	msgs := []string{}
+	for {
+		msg, err := f.ReadMessage(r)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, string(msg))
+	}
+}
+
+func TestFramers(t *testing.T) {
+	cases := []struct {
+		name   string
+		framer Framer
+		input  string
+		want   []string
+		err    error
+	}{
+		{"lines", Lines{}, "a\nb\r\n\nlast", []string{"a\n", "b\r\n", "\n", "last"}, io.EOF},
+		{"long line", Lines{}, strings.Repeat("x", 40) + "\n", []string{strings.Repeat("x", 40) + "\n"}, io.EOF},
+		{"too long", Lines{Max: 10}, "short\n" + strings.Repeat("x", 40) + "\n", []string{"short\n"}, ErrTooLong},
+		{"empty", Lines{}, "", []string{}, io.EOF},
+		{
+			"pest",
+			LengthPrefixed{Offset: 1, Size: 4, Inclusive: true},
+			"\x50\x00\x00\x00\x0a\x00\x00\x00\x00\xa6" + "\x51\x00\x00\x00\x06\xa9",
+			[]string{"\x50\x00\x00\x00\x0a\x00\x00\x00\x00\xa6", "\x51\x00\x00\x00\x06\xa9"},
+			io.EOF,
+		},
+		{
+			"exclusive",
+			LengthPrefixed{Size: 2},
+			"\x00\x03abc\x00\x00\x00\x01z",
+			[]string{"\x00\x03abc", "\x00\x00", "\x00\x01z"},
+			io.EOF,
+		},
+		{"shorter than header", LengthPrefixed{Offset: 1, Size: 4, Inclusive: true}, "\x50\x00\x00\x00\x02", []string{}, ErrBadLength},
+		{"over max", LengthPrefixed{Size: 4, Max: 100}, "\x00\x00\x01\x00", []string{}, ErrTooLong},
+		{"truncated", LengthPrefixed{Size: 1}, "\x05ab", []string{}, io.ErrUnexpectedEOF},
+		{"truncated header", LengthPrefixed{Size: 4}, "\x00\x00", []string{}, io.ErrUnexpectedEOF},
+		{"raw", Raw{Size: 10}, "0123456789abcdef", []string{"0123456789", "abcdef"}, io.EOF},
+	}
+	for _, c := range cases {
+		got, err := readAll(c.framer, []byte(c.input))
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: ended with %v, want %v", c.name, err, c.err)
+		}
+		if strings.Join(got, "|") != strings.Join(c.want, "|") || len(got) != len(c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
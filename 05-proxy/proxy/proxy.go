@@ -0,0 +1,207 @@
+// Package proxy is a man-in-the-middle TCP proxy core. A Framer splits each
+// direction of a connection into messages, a Transform can change or drop
+// them, and they're written on to the other side. When one side finishes
+// sending, the other side's write half is closed, so request/response
+// protocols that rely on half-close still work.
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Direction says which way a message is going through the proxy.
+type Direction int
+
+const (
+	ToServer Direction = 1 << iota
+	ToClient
+	Both = ToServer | ToClient
+)
+
+var ErrBadDirection = errors.New("bad direction")
+
+func (d Direction) String() string {
+	switch d {
+	case ToServer:
+		return "client-to-server"
+	case ToClient:
+		return "server-to-client"
+	case Both:
+		return "both"
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+func ParseDirection(s string) (Direction, error) {
+	for _, d := range []Direction{ToServer, ToClient, Both} {
+		if s == d.String() {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", ErrBadDirection, s)
+}
+
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Direction) UnmarshalText(text []byte) error {
+	v, err := ParseDirection(string(text))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// A Transform rewrites messages. It returns the message to send on, which
+// may be msg itself, or nil to drop it.
+type Transform interface {
+	Transform(dir Direction, msg []byte) []byte
+}
+
+type TransformFunc func(dir Direction, msg []byte) []byte
+
+func (f TransformFunc) Transform(dir Direction, msg []byte) []byte {
+	return f(dir, msg)
+}
+
+// Chain applies transforms in order, stopping if one drops the message.
+func Chain(transforms ...Transform) Transform {
+	return TransformFunc(func(dir Direction, msg []byte) []byte {
+		for _, t := range transforms {
+			msg = t.Transform(dir, msg)
+			if msg == nil {
+				return nil
+			}
+		}
+		return msg
+	})
+}
+
+// LineTransform adapts a function on lines without their line endings into
+// a Transform for messages from Lines. The ending, if any, is put back
+// afterwards.
+func LineTransform(f func(dir Direction, line string) string) Transform {
+	return TransformFunc(func(dir Direction, msg []byte) []byte {
+		body, ending := splitEnding(msg)
+		return append([]byte(f(dir, string(body))), ending...)
+	})
+}
+
+func splitEnding(line []byte) (body, ending []byte) {
+	n := len(line)
+	if n > 0 && line[n-1] == '\n' {
+		n--
+		if n > 0 && line[n-1] == '\r' {
+			n--
+		}
+	}
+	return line[:n], line[n:]
+}
+
+// Proxy relays messages between a client and a server.
+type Proxy struct {
+	// Framer splits up both directions. nil means Raw{}.
+	Framer Framer
+	// Transform, if set, sees every message.
+	Transform Transform
+	// Recorder, if set, gets a transcript of every message and what it
+	// was turned into.
+	Recorder *Recorder
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Pipe relays between client and server until both directions are done, and
+// then closes both. It returns the first error other than one side hanging
+// up.
+func (p *Proxy) Pipe(client, server net.Conn) error {
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = p.relay(client, server, ToServer)
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = p.relay(server, client, ToClient)
+	}()
+	wg.Wait()
+	client.Close()
+	server.Close()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relay copies one direction. Once in has finished cleanly, out is
+// half-closed if it can be, so the far end sees EOF but can still reply. On
+// an error, or if out can't be half-closed, both are closed so the other
+// direction stops too.
+func (p *Proxy) relay(in, out net.Conn, dir Direction) error {
+	framer := p.Framer
+	if framer == nil {
+		framer = Raw{}
+	}
+	r := bufio.NewReader(in)
+	for {
+		msg, err := framer.ReadMessage(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			in.Close()
+			out.Close()
+			if isClosed(err) {
+				// the other direction gave up first
+				return nil
+			}
+			return fmt.Errorf("%v: %w", dir, err)
+		}
+		if p.Transform != nil {
+			var original []byte
+			if p.Recorder != nil {
+				// in case the transform changes msg in place
+				original = append([]byte(nil), msg...)
+			}
+			msg = p.Transform.Transform(dir, msg)
+			p.Recorder.Record(dir, original, msg)
+			if msg == nil {
+				continue
+			}
+		} else {
+			p.Recorder.Record(dir, msg, msg)
+		}
+		if _, err := out.Write(msg); err != nil {
+			in.Close()
+			out.Close()
+			if isClosed(err) {
+				return nil
+			}
+			return fmt.Errorf("%v: %w", dir, err)
+		}
+	}
+	if cw, ok := out.(closeWriter); ok {
+		if cw.CloseWrite() == nil {
+			return nil
+		}
+	}
+	in.Close()
+	out.Close()
+	return nil
+}
+
+func isClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseDirection(t *testing.T) {
+	for _, d := range []Direction{ToServer, ToClient, Both} {
+		got, err := ParseDirection(d.String())
+		if err != nil || got != d {
+			t.Errorf("ParseDirection(%q) = %v, %v", d.String(), got, err)
+		}
+	}
+	if _, err := ParseDirection("sideways"); !errors.Is(err, ErrBadDirection) {
+		t.Errorf("expected ErrBadDirection, got %v", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	upper := LineTransform(func(dir Direction, line string) string {
+		return strings.ToUpper(line)
+	})
+	dropSecrets := TransformFunc(func(dir Direction, msg []byte) []byte {
+		if dir == ToClient && bytes.Contains(msg, []byte("SECRET")) {
+			return nil
+		}
+		return msg
+	})
+	exclaim := LineTransform(func(dir Direction, line string) string {
+		return line + "!"
+	})
+	chain := Chain(upper, dropSecrets, exclaim)
+	cases := []struct {
+		dir  Direction
+		msg  string
+		want []byte
+	}{
+		{ToServer, "hello\r\n", []byte("HELLO!\r\n")},
+		{ToClient, "hello\n", []byte("HELLO!\n")},
+		{ToClient, "no newline", []byte("NO NEWLINE!")},
+		{ToServer, "a secret\n", []byte("A SECRET!\n")},
+		{ToClient, "a secret\n", nil},
+	}
+	for _, c := range cases {
+		if got := chain.Transform(c.dir, []byte(c.msg)); !bytes.Equal(got, c.want) || (got == nil) != (c.want == nil) {
+			t.Errorf("%v %q: got %q, want %q", c.dir, c.msg, got, c.want)
+		}
+	}
+}
+
+// startUpstream runs a server that reads everything until EOF and then
+// replies and hangs up, so it only works if the proxy passes on the client's
+// half-close.
+func startUpstream(t *testing.T, reply func(data []byte) []byte) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, _ := io.ReadAll(conn)
+				conn.Write(reply(data))
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// startProxy runs p in front of upstream.
+func startProxy(t *testing.T, p *Proxy, upstream string) (addr string, errs chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	errs = make(chan error, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", upstream)
+			if err != nil {
+				conn.Close()
+				errs <- err
+				continue
+			}
+			go func() {
+				errs <- p.Pipe(conn, server)
+			}()
+		}
+	}()
+	return l.Addr().String(), errs
+}
+
+func TestPipeHalfClose(t *testing.T) {
+	p := &Proxy{
+		Framer: Lines{},
+		Transform: LineTransform(func(dir Direction, line string) string {
+			if dir == ToServer {
+				return strings.ReplaceAll(line, "cat", "dog")
+			}
+			return "[" + line + "]"
+		}),
+	}
+	addr, errs := startProxy(t, p, startUpstream(t, func(data []byte) []byte {
+		return []byte(fmt.Sprintf("got %q\n", data))
+	}))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the last line has no newline, and still has to get through
+	io.WriteString(conn, "my cat\nyour cat")
+	conn.(*net.TCPConn).CloseWrite()
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[got "my dog\nyour dog"]` + "\n"; string(reply) != want {
+		t.Errorf("got %q, want %q", reply, want)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("Pipe: %v", err)
+	}
+}
+
+func TestPipeLengthPrefixed(t *testing.T) {
+	// flip the message type of each Pest Control style message going up,
+	// which the upstream sends straight back
+	p := &Proxy{
+		Framer: LengthPrefixed{Offset: 1, Size: 4, Inclusive: true},
+		Transform: TransformFunc(func(dir Direction, msg []byte) []byte {
+			if dir == ToServer {
+				msg[0] ^= 0xff
+			}
+			return msg
+		}),
+	}
+	addr, errs := startProxy(t, p, startUpstream(t, func(data []byte) []byte {
+		return data
+	}))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("\x50\x00\x00\x00\x07ab" + "\x51\x00\x00\x00\x05"))
+	conn.(*net.TCPConn).CloseWrite()
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\xaf\x00\x00\x00\x07ab\xae\x00\x00\x00\x05"; string(reply) != want {
+		t.Errorf("got %q, want %q", reply, want)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("Pipe: %v", err)
+	}
+}
+
+func TestPipeBadFrame(t *testing.T) {
+	p := &Proxy{Framer: Lines{Max: 8}}
+	addr, errs := startProxy(t, p, startUpstream(t, func(data []byte) []byte {
+		return data
+	}))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "this line is far too long\n")
+	// the proxy gives up on both sides
+	io.ReadAll(conn)
+	if err := <-errs; !errors.Is(err, ErrTooLong) {
+		t.Errorf("Pipe: got %v, want ErrTooLong", err)
+	}
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TranscriptRecord is one message as it went through a Proxy: what arrived,
+// and what was sent on in its place. Transcripts are stored as JSON lines,
+// one record per line. Messages in binary framings needn't be UTF-8, so they
+// are stored as base64.
+type TranscriptRecord struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"dir"`
+	Original  []byte    `json:"original"`
+	Rewritten []byte    `json:"rewritten"`
+	// Dropped is set if a Transform dropped the message.
+	Dropped bool `json:"dropped,omitempty"`
+}
+
+func (r TranscriptRecord) Changed() bool {
+	return r.Dropped || !bytes.Equal(r.Original, r.Rewritten)
+}
+
+func (r TranscriptRecord) arrow() string {
+	if r.Direction == ToServer {
+		return "C->S"
+	}
+	return "S->C"
+}
+
+// String shows the record on one line, or two if the message was changed.
+func (r TranscriptRecord) String() string {
+	s := fmt.Sprintf("%s %s %q", r.Time.Format("15:04:05.000"), r.arrow(), r.Original)
+	if r.Dropped {
+		s += "\n" + strings.Repeat(" ", 14) + "=> dropped"
+	} else if r.Changed() {
+		s += "\n" + strings.Repeat(" ", 14) + "=> " + fmt.Sprintf("%q", r.Rewritten)
+	}
+	return s
+}
+
+// Recorder writes TranscriptRecords to an io.Writer. It is safe for
+// concurrent use, and a nil *Recorder silently records nothing.
+type Recorder struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Record notes a message going through. rewritten is nil if it was dropped.
+func (r *Recorder) Record(dir Direction, original, rewritten []byte) {
+	if r == nil {
+		return
+	}
+	rec := TranscriptRecord{
+		Time:      time.Now(),
+		Direction: dir,
+		Original:  original,
+		Rewritten: rewritten,
+		Dropped:   rewritten == nil,
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.enc.Encode(rec); err != nil {
+		log.Println("error recording message", err)
+	}
+}
+
+func ReadTranscript(r io.Reader) ([]TranscriptRecord, error) {
+	records := []TranscriptRecord{}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 4*DefaultMaxMessage)
+	for line := 1; s.Scan(); line++ {
+		var rec TranscriptRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("transcript line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, s.Err()
+}
+
+type DiffOp byte
+
+const (
+	DiffSame    DiffOp = ' '
+	DiffRemoved DiffOp = '-'
+	DiffAdded   DiffOp = '+'
+)
+
+type DiffLine struct {
+	Op     DiffOp
+	Record TranscriptRecord
+}
+
+func (d DiffLine) String() string {
+	r := d.Record
+	s := fmt.Sprintf("%c %s %q", d.Op, r.arrow(), r.Original)
+	if r.Dropped {
+		s += " dropped"
+	} else if r.Changed() {
+		s += fmt.Sprintf(" => %q", r.Rewritten)
+	}
+	return s
+}
+
+// sameMessage compares records ignoring when they happened.
+func sameMessage(a, b TranscriptRecord) bool {
+	return a.Direction == b.Direction && a.Dropped == b.Dropped &&
+		bytes.Equal(a.Original, b.Original) && bytes.Equal(a.Rewritten, b.Rewritten)
+}
+
+// Diff lines up two transcripts, ignoring timestamps, using a longest common
+// subsequence. Records only in a are DiffRemoved, and only in b DiffAdded.
+func Diff(a, b []TranscriptRecord) []DiffLine {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if sameMessage(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	diff := []DiffLine{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case sameMessage(a[i], b[j]):
+			diff = append(diff, DiffLine{DiffSame, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{DiffRemoved, a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{DiffAdded, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{DiffRemoved, a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{DiffAdded, b[j]})
+	}
+	return diff
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	var transcript bytes.Buffer
+	p := &Proxy{
+		Framer: Lines{},
+		Transform: TransformFunc(func(dir Direction, msg []byte) []byte {
+			if bytes.HasPrefix(msg, []byte("secret")) {
+				return nil
+			}
+			// changes msg in place, which mustn't change the transcript
+			return bytes.ReplaceAll(msg, []byte("cat"), []byte("dog"))
+		}),
+		Recorder: NewRecorder(&transcript),
+	}
+	addr, errs := startProxy(t, p, startUpstream(t, func(data []byte) []byte {
+		return []byte(fmt.Sprintf("%d bytes\n", len(data)))
+	}))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "my cat\nsecret\nhi\n")
+	conn.(*net.TCPConn).CloseWrite()
+	io.ReadAll(conn)
+	if err := <-errs; err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+
+	records, err := ReadTranscript(&transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TranscriptRecord{
+		{Direction: ToServer, Original: []byte("my cat\n"), Rewritten: []byte("my dog\n")},
+		{Direction: ToServer, Original: []byte("secret\n"), Dropped: true},
+		{Direction: ToServer, Original: []byte("hi\n"), Rewritten: []byte("hi\n")},
+		{Direction: ToClient, Original: []byte("10 bytes\n"), Rewritten: []byte("10 bytes\n")},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %v", len(records), len(want), records)
+	}
+	for i, r := range records {
+		if r.Time.IsZero() {
+			t.Errorf("record %d has no time", i)
+		}
+		if !sameMessage(r, want[i]) {
+			t.Errorf("record %d: got %+v, want %+v", i, r, want[i])
+		}
+	}
+	if records[2].Changed() || !records[0].Changed() || !records[1].Changed() {
+		t.Errorf("Changed wrong: %v", records)
+	}
+}
+
+func TestReadTranscriptBad(t *testing.T) {
+	in := `{"dir":"client-to-server","original":"YQo=","rewritten":"YQo="}` + "\n" +
+		`{"dir":"upwards","original":"Ygo=","rewritten":"Ygo="}` + "\n"
+	_, err := ReadTranscript(strings.NewReader(in))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
+
+func TestTranscriptBinary(t *testing.T) {
+	// two different messages that aren't UTF-8, and would both come out as
+	// U+FFFD if they were stored as strings
+	a := []byte{0x00, 0x03, 0xff, 0xfe}
+	b := []byte{0x00, 0x03, 0xc3, 0x28}
+	var transcript bytes.Buffer
+	r := NewRecorder(&transcript)
+	r.Record(ToServer, a, a)
+	r.Record(ToClient, a, b)
+	records, err := ReadTranscript(&transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records", len(records))
+	}
+	if !bytes.Equal(records[0].Original, a) || !bytes.Equal(records[0].Rewritten, a) {
+		t.Errorf("got %q => %q", records[0].Original, records[0].Rewritten)
+	}
+	if !bytes.Equal(records[1].Original, a) || !bytes.Equal(records[1].Rewritten, b) {
+		t.Errorf("got %q => %q", records[1].Original, records[1].Rewritten)
+	}
+	if !records[1].Changed() {
+		t.Error("rewritten message not counted as changed")
+	}
+	x := TranscriptRecord{Direction: ToServer, Original: a, Rewritten: a}
+	y := TranscriptRecord{Direction: ToServer, Original: b, Rewritten: b}
+	if sameMessage(x, y) {
+		t.Error("different binary messages compared equal")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	rec := func(dir Direction, original, rewritten string) TranscriptRecord {
+		return TranscriptRecord{Direction: dir, Original: []byte(original), Rewritten: []byte(rewritten)}
+	}
+	a := []TranscriptRecord{
+		rec(ToClient, "Welcome\n", "Welcome\n"),
+		rec(ToServer, "alice\n", "alice\n"),
+		rec(ToServer, "pay 7abc\n", "pay 7tony\n"),
+		rec(ToClient, "bye\n", "bye\n"),
+	}
+	b := []TranscriptRecord{
+		rec(ToClient, "Welcome\n", "Welcome\n"),
+		rec(ToServer, "bob\n", "bob\n"),
+		rec(ToServer, "pay 7abc\n", "pay 7tony\n"),
+		rec(ToClient, "bye\n", "bye\n"),
+		rec(ToClient, "extra\n", "extra\n"),
+	}
+	var got []string
+	for _, line := range Diff(a, b) {
+		got = append(got, line.String())
+	}
+	want := []string{
+		`  S->C "Welcome\n"`,
+		`- C->S "alice\n"`,
+		`+ C->S "bob\n"`,
+		`  C->S "pay 7abc\n" => "pay 7tony\n"`,
+		`  S->C "bye\n"`,
+		`+ S->C "extra\n"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
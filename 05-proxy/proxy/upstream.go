@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoUpstream  = errors.New("no upstream available")
+	ErrNoHalfClose = errors.New("connection can't be half-closed")
+)
+
+// Retry says how hard Upstreams.Dial tries before giving up.
+type Retry struct {
+	// Attempts is how many times to go through all the upstreams. 0
+	// means once.
+	Attempts int
+	// Backoff is the wait before the second time through. It doubles
+	// each time after that, up to MaxBackoff if that's set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// delay is the wait before attempt n, counting from 0.
+func (r Retry) delay(n int) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	d := r.Backoff
+	for i := 1; i < n; i++ {
+		d *= 2
+		if r.MaxBackoff > 0 && d >= r.MaxBackoff {
+			break
+		}
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+// Upstreams dials one of several servers. They're tried in order, except
+// that ones that are down are tried after the ones that aren't, so traffic
+// fails over to the next server and comes back once the first is up again.
+// An upstream is down if the last dial to it, or the last Check, failed.
+type Upstreams struct {
+	Addrs []string
+	// DialTimeout bounds each connection attempt. 0 means no limit.
+	DialTimeout time.Duration
+	// GreetingTimeout, if set, is how long to wait for an upstream to
+	// send something after connecting, for protocols where the server
+	// speaks first. One that stays quiet counts as down.
+	GreetingTimeout time.Duration
+	Retry           Retry
+
+	lock sync.Mutex
+	down map[string]bool
+}
+
+// Dial connects to the first upstream that works, going through them all
+// as many times as u.Retry allows.
+func (u *Upstreams) Dial() (net.Conn, error) {
+	if len(u.Addrs) == 0 {
+		return nil, ErrNoUpstream
+	}
+	attempts := u.Retry.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var lastErr error
+	for n := 0; n < attempts; n++ {
+		time.Sleep(u.Retry.delay(n))
+		for _, addr := range u.order() {
+			conn, err := u.dial(addr)
+			u.mark(addr, err)
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+	}
+	return nil, fmt.Errorf("%w: %v", ErrNoUpstream, lastErr)
+}
+
+// Check tries every upstream once, marking each up or down.
+func (u *Upstreams) Check() {
+	for _, addr := range u.Addrs {
+		conn, err := u.dial(addr)
+		if err == nil {
+			conn.Close()
+		}
+		u.mark(addr, err)
+	}
+}
+
+// Watch runs Check every interval until stop is closed.
+func (u *Upstreams) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			u.Check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Up reports whether addr was working last time it was tried.
+func (u *Upstreams) Up(addr string) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return !u.down[addr]
+}
+
+func (u *Upstreams) order() []string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	var up, down []string
+	for _, addr := range u.Addrs {
+		if u.down[addr] {
+			down = append(down, addr)
+		} else {
+			up = append(up, addr)
+		}
+	}
+	return append(up, down...)
+}
+
+func (u *Upstreams) mark(addr string, err error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.down == nil {
+		u.down = make(map[string]bool)
+	}
+	if err != nil && !u.down[addr] {
+		log.Println("Upstream", addr, "is down:", err)
+	} else if err == nil && u.down[addr] {
+		log.Println("Upstream", addr, "is back up")
+	}
+	u.down[addr] = err != nil
+}
+
+func (u *Upstreams) dial(addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, u.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if u.GreetingTimeout == 0 {
+		return conn, nil
+	}
+	conn.SetReadDeadline(time.Now().Add(u.GreetingTimeout))
+	r := bufio.NewReader(conn)
+	if _, err := r.Peek(1); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("waiting for greeting from %s: %w", addr, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	return &greetedConn{Conn: conn, r: r}, nil
+}
+
+// greetedConn is a connection whose first bytes have already been read
+// into r.
+type greetedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *greetedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *greetedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return ErrNoHalfClose
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	r := Retry{Backoff: 100 * time.Millisecond, MaxBackoff: 500 * time.Millisecond}
+	want := []time.Duration{0, 100, 200, 400, 500, 500}
+	for n, w := range want {
+		if got := r.delay(n); got != w*time.Millisecond {
+			t.Errorf("delay(%d) = %v, want %v", n, got, w*time.Millisecond)
+		}
+	}
+	if got := (Retry{Backoff: time.Second}).delay(5); got != 16*time.Second {
+		t.Errorf("uncapped delay(5) = %v", got)
+	}
+}
+
+// greeter runs a server that sends greeting to everyone who connects, or
+// nothing if it's empty.
+func greeter(t *testing.T, greeting string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.WriteString(conn, greeting)
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// deadAddr is an address nothing is listening on.
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func readGreeting(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	buf := make([]byte, 100)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestUpstreamsFailover(t *testing.T) {
+	dead := deadAddr(t)
+	quiet := greeter(t, "")
+	alive := greeter(t, "hello\n")
+	u := &Upstreams{
+		Addrs:           []string{dead, quiet, alive},
+		DialTimeout:     time.Second,
+		GreetingTimeout: 200 * time.Millisecond,
+	}
+	conn, err := u.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the greeting we waited for still gets through
+	if got := readGreeting(t, conn); got != "hello\n" {
+		t.Errorf("got %q", got)
+	}
+	if err := conn.(*greetedConn).CloseWrite(); err != nil {
+		t.Errorf("CloseWrite: %v", err)
+	}
+	conn.Close()
+	if u.Up(dead) || u.Up(quiet) || !u.Up(alive) {
+		t.Errorf("expected only %s up", alive)
+	}
+	if got := u.order(); got[0] != alive {
+		t.Errorf("expected %s first, got %v", alive, got)
+	}
+
+	// once the first is back, Check notices and it's preferred again
+	u.Addrs[1] = greeter(t, "hi\n")
+	u.Check()
+	if got := u.order(); got[0] != u.Addrs[1] || got[1] != alive || got[2] != dead {
+		t.Errorf("got order %v", got)
+	}
+}
+
+func TestUpstreamsGiveUp(t *testing.T) {
+	u := &Upstreams{
+		Addrs: []string{deadAddr(t), deadAddr(t)},
+		Retry: Retry{Attempts: 3, Backoff: 50 * time.Millisecond},
+	}
+	start := time.Now()
+	_, err := u.Dial()
+	if !errors.Is(err, ErrNoUpstream) {
+		t.Errorf("expected ErrNoUpstream, got %v", err)
+	}
+	// two waits, of 50ms and 100ms
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("gave up after only %v", elapsed)
+	}
+	if _, err := (&Upstreams{}).Dial(); !errors.Is(err, ErrNoUpstream) {
+		t.Errorf("expected ErrNoUpstream with no upstreams, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+// lrcpcap inspects captures written by the LRCP server's -capture flag.
+//
+//	lrcpcap analyze capture.jsonl
+//	lrcpcap replay [-handler reverse] [-speed 1] [-settle 5s] [-out replay.jsonl] capture.jsonl
+//
+// analyze reports ordering, retransmission and ack anomalies per session.
+// replay sends the inbound half of a capture to a fresh Listener and then
+// analyzes what that Listener did with it.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"z10f.com/golang/protohackers/07/lineapp"
+	"z10f.com/golang/protohackers/07/lrcp"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: lrcpcap analyze capture.jsonl")
+	fmt.Fprintln(os.Stderr, "       lrcpcap replay [flags] capture.jsonl")
+	os.Exit(2)
+}
+
+func readCaptureFile(name string) ([]lrcp.CaptureRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return lrcp.ReadCapture(f)
+}
+
+func report(w io.Writer, records []lrcp.CaptureRecord) {
+	anomalies := lrcp.Analyze(records)
+	bySession := make(map[uint32][]lrcp.Anomaly)
+	for _, a := range anomalies {
+		bySession[a.SessionID] = append(bySession[a.SessionID], a)
+	}
+	ids := []uint32{}
+	for id := range bySession {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	fmt.Fprintf(w, "%d packets, %d anomalies\n", len(records), len(anomalies))
+	for _, id := range ids {
+		fmt.Fprintf(w, "session %d: %d anomalies\n", id, len(bySession[id]))
+		for _, a := range bySession[id] {
+			fmt.Fprintf(w, "  %s\n", a)
+		}
+	}
+}
+
+// replay sends every inbound datagram in records to a new Listener, one UDP
+// socket per original peer address, keeping the original spacing divided by
+// speed (0 means as fast as possible). It returns the new Listener's capture.
+func replay(records []lrcp.CaptureRecord, handler lineapp.Handler, speed float64, settle time.Duration) ([]lrcp.CaptureRecord, error) {
+	var captured lrcp.CaptureBuffer
+	l, err := lrcp.ListenRecorded("lrcp", "127.0.0.1:0", lrcp.NewRecorder(&captured))
+	if err != nil {
+		return nil, err
+	}
+	app := &lineapp.Server{Handler: handler}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go app.Serve(conn)
+		}
+	}()
+	target, err := net.ResolveUDPAddr("udp", l.Addr().String())
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	peers := make(map[string]net.PacketConn)
+	defer func() {
+		for _, peer := range peers {
+			peer.Close()
+		}
+	}()
+	var start time.Time
+	var first time.Time
+	for _, rec := range records {
+		if rec.Direction != lrcp.DirectionIn {
+			continue
+		}
+		if first.IsZero() {
+			first = rec.Time
+			start = time.Now()
+		} else if speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / speed))
+			time.Sleep(time.Until(due))
+		}
+		peer, ok := peers[rec.Addr]
+		if !ok {
+			peer, err = net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				l.Close()
+				return nil, err
+			}
+			peers[rec.Addr] = peer
+			// we only care about the Listener's side, which it records
+			// itself, but something has to read the replies
+			go func(peer net.PacketConn) {
+				buf := make([]byte, 1100)
+				for {
+					if _, _, err := peer.ReadFrom(buf); err != nil {
+						return
+					}
+				}
+			}(peer)
+		}
+		if _, err := peer.WriteTo(rec.Data, target); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	time.Sleep(settle)
+	l.Close()
+	return lrcp.ReadCapture(bytes.NewReader(captured.Bytes()))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "analyze":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		records, err := readCaptureFile(os.Args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		report(os.Stdout, records)
+	case "replay":
+		fs := flag.NewFlagSet("replay", flag.ExitOnError)
+		handlerName := fs.String("handler", "reverse",
+			fmt.Sprintf("line handler to serve (one of %v)", lineapp.Names()))
+		speed := fs.Float64("speed", 1, "replay speed multiplier; 0 sends everything at once")
+		settle := fs.Duration("settle", 5*time.Second,
+			"how long to keep listening after the last packet, to catch retransmissions")
+		out := fs.String("out", "", "also write the replay's capture to this file")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() != 1 {
+			usage()
+		}
+		handler, err := lineapp.Lookup(*handlerName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		records, err := readCaptureFile(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the Listener logs every packet; that's what we're trying to get
+		// away from
+		log.SetOutput(io.Discard)
+		replayed, err := replay(records, handler, *speed, *settle)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *out != "" {
+			f, err := os.Create(*out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			err = lrcp.WriteCapture(f, replayed)
+			f.Close()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		report(os.Stdout, replayed)
+	default:
+		usage()
+	}
+}
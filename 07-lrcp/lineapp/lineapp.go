@@ -0,0 +1,181 @@
+package lineapp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+// The LRCP spec doesn't put a limit on line length, but we have to stop
+// somewhere. This is comfortably larger than bufio.Scanner's 64 KiB default.
+const DefaultMaxLineLength = 1024 * 1024
+
+// A Handler turns one line of input (without the trailing newline) into one
+// line of output (also without the trailing newline).
+//
+// Handlers may modify and return the slice they are given; the Server is done
+// with it once the reply has been written.
+type Handler interface {
+	HandleLine(line []byte) []byte
+}
+
+type HandlerFunc func(line []byte) []byte
+
+func (f HandlerFunc) HandleLine(line []byte) []byte {
+	return f(line)
+}
+
+// Server runs a Handler over every line of a connection. The zero value isn't
+// useful; set Handler.
+type Server struct {
+	Handler Handler
+	// Lines longer than this are discarded (and logged) rather than tearing
+	// down the session. Zero means DefaultMaxLineLength.
+	MaxLineLength int
+}
+
+func (s *Server) maxLineLength() int {
+	if s.MaxLineLength <= 0 {
+		return DefaultMaxLineLength
+	}
+	return s.MaxLineLength
+}
+
+// Serve handles conn until it hits EOF or an error, then closes it.
+func (s *Server) Serve(conn net.Conn) {
+	log.Println("Handling request from", conn.RemoteAddr())
+	defer conn.Close()
+	max := s.maxLineLength()
+	r := bufio.NewReader(conn)
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			if err != io.EOF {
+				log.Println("error reading", err)
+			}
+			return
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+			length := len(line)
+			if err == nil {
+				length-- // don't count the newline
+			}
+			if length > max {
+				log.Printf("APPLICATION: line from %s exceeds %d bytes, discarding", conn.RemoteAddr(), max)
+				tooLong = true
+				line = line[:0]
+			}
+		}
+		if err != nil {
+			// ErrBufferFull, keep reading the rest of the line
+			continue
+		}
+		if tooLong {
+			tooLong = false
+			continue
+		}
+
+		data := line[:len(line)-1]
+		log.Println("APPLICATION: Got input", string(data))
+		data = append(s.Handler.HandleLine(data), '\n')
+		log.Println("APPLICATION: Writing reply", string(data))
+		_, err = conn.Write(data)
+		if err != nil {
+			log.Println("error writing", err)
+			return
+		}
+		line = line[:0]
+	}
+}
+
+func reverse(s []byte) []byte {
+	rev := s[:]
+	for i, j := 0, len(rev)-1; i < j; i, j = i+1, j-1 {
+		rev[i], rev[j] = rev[j], rev[i]
+	}
+	return rev
+}
+
+var Reverse = HandlerFunc(reverse)
+
+var Echo = HandlerFunc(func(line []byte) []byte {
+	return line
+})
+
+var Upper = HandlerFunc(bytes.ToUpper)
+
+type jsonRequest struct {
+	Op   string `json:"op"`
+	Data string `json:"data"`
+}
+
+type jsonResponse struct {
+	Data  *string `json:"data,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+// JSON returns a Handler that expects each line to be a request like
+// {"op":"reverse","data":"hello"}, runs data through the named handler, and
+// replies with {"data":"olleh"} or {"error":"..."}.
+func JSON(handlers map[string]Handler) Handler {
+	return HandlerFunc(func(line []byte) []byte {
+		var req jsonRequest
+		var res jsonResponse
+		if err := json.Unmarshal(line, &req); err != nil {
+			res.Error = fmt.Sprintf("bad request: %s", err)
+		} else if h, ok := handlers[req.Op]; !ok {
+			res.Error = fmt.Sprintf("unknown op %q", req.Op)
+		} else {
+			data := string(h.HandleLine([]byte(req.Data)))
+			res.Data = &data
+		}
+		out, err := json.Marshal(res)
+		if err != nil {
+			// can't happen with the types above
+			panic(err)
+		}
+		return out
+	})
+}
+
+// Handlers are the plain line handlers selectable by name. "json" is handled
+// separately by Lookup since it wraps these.
+var Handlers = map[string]Handler{
+	"reverse": Reverse,
+	"echo":    Echo,
+	"upper":   Upper,
+}
+
+var ErrUnknownHandler = errors.New("unknown handler")
+
+// Names lists everything Lookup accepts.
+func Names() []string {
+	names := []string{"json"}
+	for name := range Handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func Lookup(name string) (Handler, error) {
+	if name == "json" {
+		return JSON(Handlers), nil
+	}
+	h, ok := Handlers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q (want one of %s)", ErrUnknownHandler, name,
+			strings.Join(Names(), ", "))
+	}
+	return h, nil
+}
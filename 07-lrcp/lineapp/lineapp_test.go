@@ -0,0 +1,82 @@
+package lineapp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+type ReverseTest struct {
+	String  string
+	Reverse string
+}
+
+var reverses = []ReverseTest{
+	{"hello", "olleh"},
+	{"four", "ruof"},
+}
+
+func TestReverse(t *testing.T) {
+	for idx, i := range reverses {
+		rev := reverse([]byte(i.String))
+		if !bytes.Equal(rev, []byte(i.Reverse)) {
+			t.Errorf("case %d failed: expected %#v, got %#v", idx, i.Reverse, rev)
+		}
+	}
+}
+
+type LineCase struct {
+	Handler string
+	Input   string
+	Output  string
+}
+
+var lineCases = []LineCase{
+	{"echo", "hello", "hello"},
+	{"upper", "hello World", "HELLO WORLD"},
+	{"reverse", "abc", "cba"},
+	{"json", `{"op":"reverse","data":"abc"}`, `{"data":"cba"}`},
+	{"json", `{"op":"upper","data":""}`, `{"data":""}`},
+	{"json", `{"op":"json","data":"abc"}`, `{"error":"unknown op \"json\""}`},
+}
+
+func TestHandlers(t *testing.T) {
+	for idx, i := range lineCases {
+		h, err := Lookup(i.Handler)
+		if err != nil {
+			t.Fatalf("case %d: %s", idx, err)
+		}
+		out := h.HandleLine([]byte(i.Input))
+		if string(out) != i.Output {
+			t.Errorf("case %d failed: expected %#v, got %#v", idx, i.Output, string(out))
+		}
+	}
+}
+
+func TestJSONBadRequest(t *testing.T) {
+	out := JSON(Handlers).HandleLine([]byte("not json"))
+	if !bytes.HasPrefix(out, []byte(`{"error":"bad request`)) {
+		t.Fatalf("expected bad request error, got %s", out)
+	}
+}
+
+func TestLongLineDiscarded(t *testing.T) {
+	server, client := net.Pipe()
+	app := &Server{Handler: Echo, MaxLineLength: 10}
+	go app.Serve(server)
+	defer client.Close()
+	go func() {
+		// long enough to cross bufio.Reader's internal buffer too
+		client.Write([]byte(strings.Repeat("x", 10000) + "\n"))
+		client.Write([]byte("0123456789\n"))
+	}()
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "0123456789\n" {
+		t.Fatalf("expected only the short line back, got %#v", line)
+	}
+}
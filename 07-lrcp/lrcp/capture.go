@@ -0,0 +1,254 @@
+package lrcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	DirectionIn  Direction = "in"
+	DirectionOut Direction = "out"
+)
+
+// CaptureRecord is one datagram as seen by a Listener. Captures are stored as
+// JSON lines, one record per line.
+type CaptureRecord struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"dir"`
+	Addr      string    `json:"addr"`
+	// Data is the raw datagram, before any parsing or unescaping. Peers
+	// can send anything, so it's stored as base64.
+	Data []byte `json:"data"`
+}
+
+// Recorder writes CaptureRecords to an io.Writer. It is safe for concurrent
+// use, and a nil *Recorder silently records nothing.
+type Recorder struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+func (r *Recorder) Record(dir Direction, addr net.Addr, data []byte) {
+	if r == nil {
+		return
+	}
+	rec := CaptureRecord{
+		Time:      time.Now(),
+		Direction: dir,
+		Addr:      addr.String(),
+		Data:      append([]byte(nil), data...),
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.enc.Encode(rec); err != nil {
+		log.Println("error recording packet", err)
+	}
+}
+
+// CaptureBuffer holds a capture in memory. Unlike a bytes.Buffer, it can be
+// read while a Listener is still recording into it.
+type CaptureBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *CaptureBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of what has been recorded so far.
+func (b *CaptureBuffer) Bytes() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]byte{}, b.buf.Bytes()...)
+}
+
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	records := []CaptureRecord{}
+	s := bufio.NewScanner(r)
+	// data packets are well under 1000 bytes, but escaping in JSON can grow
+	// them a fair bit
+	s.Buffer(nil, 64*1024)
+	for line := 1; s.Scan(); line++ {
+		var rec CaptureRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("capture line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, s.Err()
+}
+
+func WriteCapture(w io.Writer, records []CaptureRecord) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type AnomalyKind string
+
+const (
+	AnomalyMalformed        AnomalyKind = "malformed packet"
+	AnomalyNoSession        AnomalyKind = "packet for unknown session"
+	AnomalyAfterClose       AnomalyKind = "packet after close"
+	AnomalyDataGap          AnomalyKind = "data arrived out of order"
+	AnomalyRetransmission   AnomalyKind = "retransmission"
+	AnomalyAckBackwards     AnomalyKind = "ack went backwards"
+	AnomalyDuplicateAck     AnomalyKind = "duplicate ack"
+	AnomalyAckBeyondSent    AnomalyKind = "ack beyond data sent"
+	AnomalyDuplicateConnect AnomalyKind = "duplicate connect"
+)
+
+type Anomaly struct {
+	Time      time.Time
+	SessionID uint32
+	Direction Direction
+	Kind      AnomalyKind
+	Detail    string
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%s [%d] %s: %s: %s", a.Time.Format(time.RFC3339Nano),
+		a.SessionID, a.Direction, a.Kind, a.Detail)
+}
+
+// streamState tracks one direction of a session's byte stream.
+type streamState struct {
+	// sent is the end of the contiguous data the sender has put on the wire.
+	sent uint32
+	// acked is the highest ack the receiver has sent for this stream.
+	acked uint32
+}
+
+type sessionState struct {
+	closed bool
+	// keyed by the direction the data packets travel in
+	streams map[Direction]*streamState
+}
+
+func opposite(dir Direction) Direction {
+	if dir == DirectionIn {
+		return DirectionOut
+	}
+	return DirectionIn
+}
+
+func sessionOf(packet interface{}) uint32 {
+	switch p := packet.(type) {
+	case ConnectPacket:
+		return p.SessionID
+	case DataPacket:
+		return p.SessionID
+	case AckPacket:
+		return p.SessionID
+	case ClosePacket:
+		return p.SessionID
+	}
+	panic("sessionOf: unknown packet type")
+}
+
+// Analyze walks a capture in order and reports anything that a well-behaved
+// pair of peers on a lossless network wouldn't have done: gaps and
+// retransmissions in either data stream, acks that repeat, go backwards or
+// acknowledge data that was never sent, and traffic on closed sessions.
+//
+// Some of these are expected on a real network (that's what retransmission is
+// for); the point is to make them easy to find.
+func Analyze(records []CaptureRecord) []Anomaly {
+	anomalies := []Anomaly{}
+	sessions := make(map[uint32]*sessionState)
+	for _, rec := range records {
+		report := func(id uint32, kind AnomalyKind, format string, args ...interface{}) {
+			anomalies = append(anomalies, Anomaly{
+				Time:      rec.Time,
+				SessionID: id,
+				Direction: rec.Direction,
+				Kind:      kind,
+				Detail:    fmt.Sprintf(format, args...),
+			})
+		}
+		packet, err := parsePacket(rec.Data)
+		if err != nil {
+			report(0, AnomalyMalformed, "%s: %q", err, rec.Data)
+			continue
+		}
+		id := sessionOf(packet)
+		session, ok := sessions[id]
+		if connect, isConnect := packet.(ConnectPacket); isConnect {
+			if ok && !session.closed {
+				report(connect.SessionID, AnomalyDuplicateConnect, "session already open")
+				continue
+			}
+			sessions[id] = &sessionState{
+				streams: map[Direction]*streamState{
+					DirectionIn:  {},
+					DirectionOut: {},
+				},
+			}
+			continue
+		}
+		if !ok {
+			report(id, AnomalyNoSession, "%q", rec.Data)
+			continue
+		}
+		if _, isClose := packet.(ClosePacket); session.closed && !isClose {
+			// the close reply to a close is expected
+			report(id, AnomalyAfterClose, "%q", rec.Data)
+			continue
+		}
+		switch p := packet.(type) {
+		case DataPacket:
+			stream := session.streams[rec.Direction]
+			end := p.Position + uint32(len(p.Data))
+			if p.Position > stream.sent {
+				report(id, AnomalyDataGap, "data at %d but only %d bytes so far",
+					p.Position, stream.sent)
+			} else if end <= stream.sent {
+				report(id, AnomalyRetransmission, "bytes %d-%d sent again",
+					p.Position, end)
+			} else {
+				if p.Position < stream.sent {
+					report(id, AnomalyRetransmission, "bytes %d-%d sent again",
+						p.Position, stream.sent)
+				}
+				stream.sent = end
+			}
+		case AckPacket:
+			// acks travel the opposite way to the data they acknowledge
+			stream := session.streams[opposite(rec.Direction)]
+			if p.Length > stream.sent {
+				report(id, AnomalyAckBeyondSent, "ack of %d but only %d bytes sent",
+					p.Length, stream.sent)
+			} else if p.Length < stream.acked {
+				report(id, AnomalyAckBackwards, "ack of %d after ack of %d",
+					p.Length, stream.acked)
+			} else if p.Length == stream.acked && p.Length != 0 {
+				report(id, AnomalyDuplicateAck, "ack of %d repeated", p.Length)
+			} else {
+				stream.acked = p.Length
+			}
+		case ClosePacket:
+			session.closed = true
+		}
+	}
+	return anomalies
+}
@@ -0,0 +1,150 @@
+package lrcp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func captureOf(packets ...string) []CaptureRecord {
+	records := []CaptureRecord{}
+	for _, p := range packets {
+		dir := DirectionIn
+		if p[0] == '>' {
+			dir = DirectionOut
+		}
+		records = append(records, CaptureRecord{
+			Direction: dir,
+			Addr:      "127.0.0.1:1234",
+			Data:      []byte(p[1:]),
+		})
+	}
+	return records
+}
+
+type AnalyzeCase struct {
+	Capture []CaptureRecord
+	Kinds   []AnomalyKind
+}
+
+var analyzeCases = []AnalyzeCase{
+	{captureOf(
+		"</connect/1/", ">/ack/1/0/",
+		"</data/1/0/hello\n/", ">/ack/1/6/",
+		">/data/1/0/olleh\n/", "</ack/1/6/",
+		"</close/1/", ">/close/1/",
+	), []AnomalyKind{}},
+	{captureOf(
+		"</connect/1/", ">/ack/1/0/",
+		"</data/1/0/hello/", ">/ack/1/5/",
+		"</data/1/0/hello/", ">/ack/1/5/",
+	), []AnomalyKind{AnomalyRetransmission, AnomalyDuplicateAck}},
+	{captureOf(
+		"</connect/1/",
+		"</data/1/5/world/", ">/ack/1/0/",
+		">/data/1/0/abc/", "</ack/1/4/",
+	), []AnomalyKind{AnomalyDataGap, AnomalyAckBeyondSent}},
+	{captureOf(
+		"</connect/1/",
+		">/data/1/0/abcdef/", "</ack/1/6/", "</ack/1/3/",
+	), []AnomalyKind{AnomalyAckBackwards}},
+	{captureOf(
+		"</data/2/0/hello/", "</connect/1/", "</connect/1/",
+		"</close/1/", "</data/1/0/x/", "<garbage",
+	), []AnomalyKind{AnomalyNoSession, AnomalyDuplicateConnect, AnomalyAfterClose, AnomalyMalformed}},
+}
+
+func TestAnalyze(t *testing.T) {
+	for idx, i := range analyzeCases {
+		kinds := []AnomalyKind{}
+		for _, a := range Analyze(i.Capture) {
+			kinds = append(kinds, a.Kind)
+		}
+		if !reflect.DeepEqual(kinds, i.Kinds) {
+			t.Errorf("case %d failed: expected %v, got %v", idx, i.Kinds, kinds)
+		}
+	}
+}
+
+func TestCaptureRoundTrip(t *testing.T) {
+	// the last packet isn't UTF-8, and has to come back byte for byte
+	records := captureOf("</connect/1/", ">/ack/1/0/", "</data/1/0/a\\/b\n/", "</data/1/4/\xff\xfe\xc3\x28/")
+	for idx := range records {
+		records[idx].Time = time.Unix(1666000000, int64(idx)).UTC()
+	}
+	var buf bytes.Buffer
+	if err := WriteCapture(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, read) {
+		t.Fatalf("expected %#v, got %#v", records, read)
+	}
+}
+
+func TestListenerRecords(t *testing.T) {
+	var buf CaptureBuffer
+	l, err := ListenRecorded("lrcp", "127.0.0.1:0", NewRecorder(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("/connect/7/")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 100)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply[:n]) != "/ack/7/0/" {
+		t.Fatalf("unexpected reply %q", reply[:n])
+	}
+
+	// the ack is recorded before it's sent, so it's there by now
+	records, err := ReadCapture(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 ||
+		records[0].Direction != DirectionIn || string(records[0].Data) != "/connect/7/" ||
+		records[1].Direction != DirectionOut || string(records[1].Data) != "/ack/7/0/" {
+		t.Fatalf("unexpected capture %#v", records)
+	}
+}
+
+func TestAcceptAfterClose(t *testing.T) {
+	l, err := Listen("lrcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+	l.Close()
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("got %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept didn't return after Close")
+	}
+}
@@ -56,7 +56,11 @@ type Listener struct {
 	packetChan     chan IncomingPacket
 	address        LrcpAddr
 	connections    map[uint32]*Conn
-	ticker         time.Ticker
+	recorder       *Recorder
+	// closed is closed by Close, to stop Accept and the Listener's
+	// goroutines.
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
 var ErrInvalidUint32 = errors.New("invalid uint32 passed to parseUint32")
@@ -229,7 +233,9 @@ func serializePacket(packet interface{}) []byte {
 
 func (l *Listener) sendPacket(addr net.Addr, packet interface{}) error {
 	log.Printf("[%s]: sending packet %#v", addr, packet)
-	_, err := l.udpConn.WriteTo(serializePacket(packet), addr)
+	data := serializePacket(packet)
+	l.recorder.Record(DirectionOut, addr, data)
+	_, err := l.udpConn.WriteTo(data, addr)
 	return err
 }
 
@@ -255,7 +261,10 @@ func (l *Listener) dispatchPacket(packet interface{}, addr net.Addr) {
 			}
 			conn.sendAck(p.SessionID)
 			l.connections[p.SessionID] = conn
-			l.newConnections <- conn
+			select {
+			case l.newConnections <- conn:
+			case <-l.closed:
+			}
 		}
 	case DataPacket:
 		if conn, ok := l.connections[p.SessionID]; ok {
@@ -343,13 +352,18 @@ func (l *Listener) readPackets() {
 			return
 		}
 		log.Printf("[%s] len(buf) was %d, n was %d", addr, len(buf), n)
+		l.recorder.Record(DirectionIn, addr, buf[:n])
 		packet, err := parsePacket(buf[:n])
 		if err != nil {
 			log.Printf("[%s] packet was invalid, ignoring: %s", addr, err)
 			continue
 		}
 		log.Printf("[%s] received packet %#v", addr, packet)
-		l.packetChan <- IncomingPacket{Addr: addr, Packet: packet}
+		select {
+		case l.packetChan <- IncomingPacket{Addr: addr, Packet: packet}:
+		case <-l.closed:
+			return
+		}
 	}
 }
 
@@ -365,14 +379,16 @@ func (l *Listener) doRetransmissions() {
 
 func (l *Listener) handlePackets() {
 	go l.readPackets()
-	l.ticker = *time.NewTicker(1 * time.Second)
-	defer l.ticker.Stop()
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case incoming := <-l.packetChan:
 			l.dispatchPacket(incoming.Packet, incoming.Addr)
-		case <-l.ticker.C:
+		case <-ticker.C:
 			l.doRetransmissions()
+		case <-l.closed:
+			return
 		}
 	}
 }
@@ -380,6 +396,12 @@ func (l *Listener) handlePackets() {
 var ErrInvalidNetworkType = errors.New("bad network type")
 
 func Listen(network, address string) (*Listener, error) {
+	return ListenRecorded(network, address, nil)
+}
+
+// ListenRecorded is like Listen, but every datagram the Listener sends or
+// receives is also written to rec. A nil rec records nothing.
+func ListenRecorded(network, address string, rec *Recorder) (*Listener, error) {
 	if network != "lrcp" {
 		return nil, ErrInvalidNetworkType
 	}
@@ -390,23 +412,31 @@ func Listen(network, address string) (*Listener, error) {
 	listener := &Listener{
 		udpConn:        conn,
 		newConnections: make(chan *Conn),
-		address:        LrcpAddr{address},
+		address:        LrcpAddr{conn.LocalAddr().String()},
 		connections:    make(map[uint32]*Conn),
 		packetChan:     make(chan IncomingPacket),
+		recorder:       rec,
+		closed:         make(chan struct{}),
 	}
 	go listener.handlePackets()
 	return listener, nil
 }
 
+// Accept waits for a new session. Once the Listener is closed it returns
+// net.ErrClosed.
 func (l *Listener) Accept() (*Conn, error) {
-	conn, ok := <-l.newConnections
-	if ok {
+	select {
+	case conn := <-l.newConnections:
 		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
 	}
-	return nil, fmt.Errorf("error reading from newConnections channel")
 }
 
 func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
 	return l.udpConn.Close()
 }
 
@@ -1,41 +1,50 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"log"
-	"net"
+	"os"
 
+	"z10f.com/golang/protohackers/07/lineapp"
 	"z10f.com/golang/protohackers/07/lrcp"
 )
 
-func reverse(s []byte) []byte {
-	rev := s[:]
-	for i, j := 0, len(rev)-1; i < j; i, j = i+1, j-1 {
-		rev[i], rev[j] = rev[j], rev[i]
+var listenAddr = flag.String("listen", ":1337", "address to listen on")
+var handlerName = flag.String("handler", "reverse",
+	fmt.Sprintf("line handler to serve (one of %v)", lineapp.Names()))
+var maxLine = flag.Int("maxline", lineapp.DefaultMaxLineLength,
+	"longest line accepted; longer lines are discarded")
+var capturePath = flag.String("capture", "",
+	"append every LRCP datagram to this file as JSON lines (see cmd/lrcpcap)")
+
+func newApp(name string, maxLineLength int) (*lineapp.Server, error) {
+	handler, err := lineapp.Lookup(name)
+	if err != nil {
+		return nil, err
 	}
-	return rev
+	return &lineapp.Server{
+		Handler:       handler,
+		MaxLineLength: maxLineLength,
+	}, nil
 }
 
-func handleRequest(conn net.Conn) {
-	log.Println("Handling request from", conn.RemoteAddr())
-	defer conn.Close()
-	for s := bufio.NewScanner(conn); s.Scan(); {
-		data := s.Bytes()
-		log.Println("APPLICATION: Got input", string(data))
-		data = reverse(data)
-		data = append(data, '\n')
-		log.Println("APPLICATION: Writing reply", string(data))
-		_, err := conn.Write(data)
+func main() {
+	flag.Parse()
+	app, err := newApp(*handlerName, *maxLine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rec *lrcp.Recorder
+	if *capturePath != "" {
+		f, err := os.OpenFile(*capturePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			log.Println("error writing", err)
-			return
+			log.Fatal("could not open capture file", err)
 		}
+		defer f.Close()
+		rec = lrcp.NewRecorder(f)
 	}
-}
-
-func main() {
-	l, err := lrcp.Listen("lrcp", ":1337")
+	l, err := lrcp.ListenRecorded("lrcp", *listenAddr, rec)
 	if err != nil {
 		log.Fatal("could not listen", err)
 	}
@@ -45,7 +54,8 @@ func main() {
 		conn, err := l.Accept()
 		if err != nil {
 			log.Println("Error accepting", err)
+			continue
 		}
-		go handleRequest(conn)
+		go app.Serve(conn)
 	}
 }
@@ -1,29 +1,12 @@
 package main
 
 import (
-	"bytes"
+	"errors"
 	"net"
 	"testing"
-)
-
-type ReverseTest struct {
-	String  string
-	Reverse string
-}
-
-var reverses = []ReverseTest{
-	{"hello", "olleh"},
-	{"four", "ruof"},
-}
 
-func TestReverse(t *testing.T) {
-	for idx, i := range reverses {
-		rev := reverse([]byte(i.String))
-		if !bytes.Equal(rev, []byte(i.Reverse)) {
-			t.Errorf("case %d failed: expected %#v, got %#v", idx, i.Reverse, rev)
-		}
-	}
-}
+	"z10f.com/golang/protohackers/07/lineapp"
+)
 
 func readToNewline(conn net.Conn) ([]byte, error) {
 	buf := []byte{}
@@ -39,12 +22,13 @@ func readToNewline(conn net.Conn) ([]byte, error) {
 }
 
 func TestConn(t *testing.T) {
+	app, err := newApp("reverse", lineapp.DefaultMaxLineLength)
+	if err != nil {
+		t.Fatal(err)
+	}
 	server, client := net.Pipe()
-	go func() {
-		defer server.Close()
-		handleRequest(server)
-	}()
-	_, err := client.Write([]byte("hello\n"))
+	go app.Serve(server)
+	_, err = client.Write([]byte("hello\n"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -70,3 +54,10 @@ func TestConn(t *testing.T) {
 	}
 	client.Close()
 }
+
+func TestNewAppUnknownHandler(t *testing.T) {
+	_, err := newApp("nope", 0)
+	if !errors.Is(err, lineapp.ErrUnknownHandler) {
+		t.Fatalf("expected ErrUnknownHandler, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+// islcrack recovers candidate cipher specs for a captured ISL stream.
+//
+//	islcrack [-depth 2] [-hint regexp] [-offset 0] capture.bin
+//
+// The capture is the raw ciphertext of one direction of a session, after the
+// cipher spec, and needs at least one complete line in it. Every stack of the
+// standard ciphers up to -depth long is tried against it, and the ones that
+// decrypt it to lines matching -hint (by default the toy-order format,
+// "Nx toy,...") are printed.
+package main
+
+import (
+    "bytes"
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "regexp"
+    "strings"
+
+    "z10f.com/golang/protohackers/08/isl"
+)
+
+const DefaultHint = `^\d+x [^,]+(,\d+x [^,]+)*$`
+
+type Result struct {
+    Spec []isl.Cipher
+    Plaintext []byte
+}
+
+type Searcher struct {
+    Ciphertext []byte
+    // Offset is the stream position of Ciphertext[0].
+    Offset uint64
+    // Every complete line of plaintext has to match Hint.
+    Hint *regexp.Regexp
+    // Every plaintext byte has to be in Allowed. This is what makes the
+    // search fast, since nearly every candidate fails on the first byte.
+    Allowed [256]bool
+    // Stop once this many results have been found.
+    MaxResults int
+
+    candidates []isl.Cipher
+    seen map[string]bool
+    results []Result
+}
+
+func NewSearcher(ciphertext []byte, hint *regexp.Regexp) *Searcher {
+    s := &Searcher{
+        Ciphertext: ciphertext,
+        Hint: hint,
+        MaxResults: 20,
+    }
+    s.Allowed['\n'] = true
+    for b := ' '; b <= '~'; b++ {
+        s.Allowed[b] = true
+    }
+    for _, op := range isl.StandardOps.Ops() {
+        info := isl.LookupCipher(op)
+        if info.Operands == 0 {
+            s.candidates = append(s.candidates, isl.Cipher{Op: op})
+            continue
+        }
+        // a zero key is a no-op for both xor and add
+        for k := 1; k < 256; k++ {
+            s.candidates = append(s.candidates, isl.Cipher{Op: op, Key: []byte{byte(k)}})
+        }
+    }
+    return s
+}
+
+// redundant reports whether following prev with next could be done by a
+// shorter stack, so we don't bother trying it.
+func redundant(prev, next isl.Cipher) bool {
+    if prev.Op != next.Op {
+        return false
+    }
+    // two xors are one xor, two adds are one add, and reversebits and xorpos
+    // undo themselves; addpos twice is genuinely different
+    return prev.Op != isl.Addpos
+}
+
+func (s *Searcher) done() bool {
+    return s.MaxResults > 0 && len(s.results) >= s.MaxResults
+}
+
+// Search tries every stack up to depth ciphers long, shortest first. Stacks
+// that produce the same plaintext as an earlier result are skipped.
+func (s *Searcher) Search(depth int) []Result {
+    s.seen = make(map[string]bool)
+    s.results = nil
+    stack := make([]isl.Cipher, 0, depth)
+    for d := 1; d <= depth && !s.done(); d++ {
+        s.extend(stack, d)
+    }
+    return s.results
+}
+
+func (s *Searcher) extend(stack []isl.Cipher, depth int) {
+    if len(stack) == depth {
+        s.try(stack)
+        return
+    }
+    for _, c := range s.candidates {
+        if s.done() {
+            return
+        }
+        if len(stack) > 0 && redundant(stack[len(stack) - 1], c) {
+            continue
+        }
+        s.extend(append(stack, c), depth)
+    }
+}
+
+func (s *Searcher) try(stack []isl.Cipher) {
+    plaintext := make([]byte, 0, len(s.Ciphertext))
+    buf := make([]byte, 1)
+    for i, b := range s.Ciphertext {
+        buf[0] = b
+        isl.Crypt(stack, buf, s.Offset + uint64(i), true)
+        if !s.Allowed[buf[0]] {
+            return
+        }
+        plaintext = append(plaintext, buf[0])
+    }
+    lines := bytes.Split(plaintext, []byte("\n"))
+    if len(lines) < 2 {
+        // without a whole line the hint can't rule anything out
+        return
+    }
+    // the last piece is either empty or an incomplete line
+    for _, line := range lines[:len(lines) - 1] {
+        if !s.Hint.Match(line) {
+            return
+        }
+    }
+    if s.seen[string(plaintext)] {
+        return
+    }
+    s.seen[string(plaintext)] = true
+    s.results = append(s.results, Result{
+        Spec: append([]isl.Cipher{}, stack...),
+        Plaintext: plaintext,
+    })
+}
+
+func main() {
+    depth := flag.Int("depth", 2, "longest cipher stack to try")
+    hint := flag.String("hint", DefaultHint, "regexp every decrypted line must match")
+    offset := flag.Uint64("offset", 0, "stream position of the first captured byte")
+    limit := flag.Int("n", 512, "only look at this many bytes of the capture (0 for all)")
+    maxResults := flag.Int("max", 20, "stop after this many candidates (0 for no limit)")
+    isHex := flag.Bool("hex", false, "the capture is hex rather than raw bytes")
+    flag.Parse()
+    if flag.NArg() != 1 {
+        fmt.Fprintln(os.Stderr, "usage: islcrack [flags] capture")
+        flag.PrintDefaults()
+        os.Exit(2)
+    }
+    hintRe, err := regexp.Compile(*hint)
+    if err != nil {
+        log.Fatal("bad hint: ", err)
+    }
+    data, err := os.ReadFile(flag.Arg(0))
+    if err != nil {
+        log.Fatal(err)
+    }
+    if *isHex {
+        data, err = hex.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+        if err != nil {
+            log.Fatal("bad hex: ", err)
+        }
+    }
+    if *limit > 0 && len(data) > *limit {
+        data = data[:*limit]
+    }
+
+    s := NewSearcher(data, hintRe)
+    s.Offset = *offset
+    s.MaxResults = *maxResults
+    results := s.Search(*depth)
+    if len(results) == 0 {
+        fmt.Println("No candidates found.")
+        os.Exit(1)
+    }
+    for _, r := range results {
+        firstLine, _, _ := bytes.Cut(r.Plaintext, []byte("\n"))
+        fmt.Printf("%s\t%q\n", isl.FormatCipherSpec(r.Spec), firstLine)
+    }
+}
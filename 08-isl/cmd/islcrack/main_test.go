@@ -0,0 +1,58 @@
+package main
+
+import (
+    "regexp"
+    "testing"
+
+    "z10f.com/golang/protohackers/08/isl"
+)
+
+type CrackCase struct {
+    Spec string
+    Offset uint64
+    Depth int
+}
+
+var crackCases []CrackCase = []CrackCase {
+    {"xor(0x7b)", 0, 1},
+    {"reversebits,add(0x10)", 7, 2},
+    {"xor(0x7b),addpos", 0, 2},
+    {"xorpos,addpos", 300, 2},
+}
+
+func TestSearch(t *testing.T) {
+    plaintext := []byte("4x dog,5x car\n10x toy car,2x cat\n3x rat")
+    for _, c := range crackCases {
+        spec, err := isl.ParseCipherSpec(c.Spec)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ciphertext := append([]byte{}, plaintext...)
+        isl.Crypt(spec, ciphertext, c.Offset, false)
+
+        s := NewSearcher(ciphertext, regexp.MustCompile(DefaultHint))
+        s.Offset = c.Offset
+        s.MaxResults = 0
+        found := false
+        for _, r := range s.Search(c.Depth) {
+            if string(r.Plaintext) == string(plaintext) {
+                found = true
+            }
+        }
+        if !found {
+            t.Errorf("Didn't recover plaintext for %s", c.Spec)
+        }
+    }
+}
+
+func TestSearchRejectsGarbage(t *testing.T) {
+    ciphertext := make([]byte, 64)
+    for i := range ciphertext {
+        ciphertext[i] = byte(i * 37)
+    }
+    s := NewSearcher(ciphertext, regexp.MustCompile(DefaultHint))
+    if results := s.Search(1); len(results) != 0 {
+        t.Fatalf("Found %d candidates in garbage, first %s", len(results),
+            isl.FormatCipherSpec(results[0].Spec))
+    }
+}
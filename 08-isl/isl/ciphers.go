@@ -0,0 +1,312 @@
+package isl
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/bits"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const (
+    End = iota
+    ReverseBits
+    Xor
+    Xorpos
+    Add
+    Addpos
+    // Everything past here is an extension; see StandardOps.
+    RotateLeft
+    Substitute
+    XorKey
+)
+
+// Cipher is one entry in a cipher spec: an opcode and however many key bytes
+// that opcode takes.
+type Cipher struct {
+    Op byte
+    Key []byte
+}
+
+func (c Cipher) Info() *CipherInfo {
+    return LookupCipher(c.Op)
+}
+
+// Validate checks that c is a registered cipher with a key it can use.
+func (c Cipher) Validate() error {
+    info := c.Info()
+    if info == nil {
+        return fmt.Errorf("%w: unknown cipher %d", ErrBadCipherSpec, c.Op)
+    }
+    if info.Operands == VariableOperands {
+        if len(c.Key) > 255 {
+            return fmt.Errorf("%w: %s key too long", ErrBadCipherSpec, info.Name)
+        }
+    } else if len(c.Key) != info.Operands {
+        return fmt.Errorf("%w: %s takes %d key bytes, got %d", ErrBadCipherSpec,
+            info.Name, info.Operands, len(c.Key))
+    }
+    if info.ValidateKey != nil {
+        if err := info.ValidateKey(c.Key); err != nil {
+            return fmt.Errorf("%w: %s: %s", ErrBadCipherSpec, info.Name, err)
+        }
+    }
+    return nil
+}
+
+func (c Cipher) String() string {
+    info := c.Info()
+    if info == nil {
+        return fmt.Sprintf("unknown(%d)", c.Op)
+    }
+    if info.Operands == 0 {
+        return info.Name
+    }
+    return fmt.Sprintf("%s(0x%s)", info.Name, hex.EncodeToString(c.Key))
+}
+
+var ErrBadCipherSpec = errors.New("bad cipher spec")
+
+// ParseCipherSpec parses the human-readable form of a cipher spec, e.g.
+// "xor(0x7b),reversebits,addpos". Single-byte keys can be given in decimal or
+// with a 0x prefix in hex; longer keys must be hex, e.g. xorkey(0xdeadbeef).
+func ParseCipherSpec(spec string) ([]Cipher, error) {
+    ciphers := make([]Cipher, 0)
+    for _, part := range strings.Split(spec, ",") {
+        part = strings.TrimSpace(part)
+        name, arg, hasArg := strings.Cut(part, "(")
+        info := LookupCipherName(name)
+        if info == nil {
+            return nil, fmt.Errorf("%w: unknown cipher %q", ErrBadCipherSpec, name)
+        }
+        if hasArg && info.Operands == 0 {
+            return nil, fmt.Errorf("%w: %s doesn't take a key", ErrBadCipherSpec, name)
+        } else if !hasArg && info.Operands != 0 {
+            return nil, fmt.Errorf("%w: %s needs a key", ErrBadCipherSpec, name)
+        }
+        var key []byte
+        if hasArg {
+            if !strings.HasSuffix(arg, ")") {
+                return nil, fmt.Errorf("%w: missing ) after %s", ErrBadCipherSpec, part)
+            }
+            arg = strings.TrimSuffix(arg, ")")
+            if info.Operands == 1 {
+                k, err := strconv.ParseUint(arg, 0, 8)
+                if err != nil {
+                    return nil, fmt.Errorf("%w: bad key for %s: %s", ErrBadCipherSpec, name, err)
+                }
+                key = []byte{byte(k)}
+            } else {
+                if !strings.HasPrefix(arg, "0x") {
+                    return nil, fmt.Errorf("%w: %s key must be hex", ErrBadCipherSpec, name)
+                }
+                var err error
+                key, err = hex.DecodeString(arg[2:])
+                if err != nil {
+                    return nil, fmt.Errorf("%w: bad key for %s: %s", ErrBadCipherSpec, name, err)
+                }
+            }
+        }
+        c := Cipher{Op: info.Op, Key: key}
+        if err := c.Validate(); err != nil {
+            return nil, err
+        }
+        ciphers = append(ciphers, c)
+    }
+    return ciphers, nil
+}
+
+func FormatCipherSpec(ciphers []Cipher) string {
+    parts := make([]string, len(ciphers))
+    for i, c := range ciphers {
+        parts[i] = c.String()
+    }
+    return strings.Join(parts, ",")
+}
+
+// MarshalCipherSpec produces the on-the-wire form of a cipher spec, including
+// the terminating End byte.
+func MarshalCipherSpec(ciphers []Cipher) ([]byte, error) {
+    buf := make([]byte, 0, 2 * len(ciphers) + 1)
+    for _, c := range ciphers {
+        if err := c.Validate(); err != nil {
+            return nil, err
+        }
+        buf = append(buf, c.Op)
+        if c.Info().Operands == VariableOperands {
+            buf = append(buf, byte(len(c.Key)))
+        }
+        buf = append(buf, c.Key...)
+    }
+    return append(buf, End), nil
+}
+
+// Crypt runs data through every cipher in turn, or backwards through their
+// inverses if reverse is set. The ciphers must be valid.
+func Crypt(ciphers []Cipher, data []byte, pos uint64, reverse bool) {
+    for n := 0; n < len(ciphers); n++ {
+        if reverse {
+            c := ciphers[len(ciphers) - n - 1]
+            c.Info().Inverse(data, pos, c.Key)
+        } else {
+            c := ciphers[n]
+            c.Info().Forward(data, pos, c.Key)
+        }
+    }
+}
+
+func ReverseBitsCipher(data []byte, pos uint64, key []byte) {
+    for i, b := range data {
+        newb := (b & 0x80) >> 7
+        newb |= (b & 0x40) >> 5
+        newb |= (b & 0x20) >> 3
+        newb |= (b & 0x10) >> 1
+        newb |= (b & 0x08) << 1
+        newb |= (b & 0x04) << 3
+        newb |= (b & 0x02) << 5
+        newb |= (b & 0x01) << 7
+        data[i] = newb
+    }
+}
+
+func XorCipher(data []byte, pos uint64, key []byte) {
+    for i, b := range data {
+        data[i] = b ^ key[0]
+    }
+}
+
+func XorposCipher(data []byte, pos uint64, key []byte) {
+    for i, b := range data {
+        data[i] = b ^ (byte(pos) + byte(i))
+    }
+}
+
+func addInverse(n byte) byte {
+    return ^n + 1
+}
+
+func AddCipher(data []byte, pos uint64, key []byte) {
+    for i, b := range data {
+        data[i] = b + key[0]
+    }
+}
+
+func SubCipher(data []byte, pos uint64, key []byte) {
+    k := addInverse(key[0])
+    for i, b := range data {
+        data[i] = b + k
+    }
+}
+
+func AddposCipher(data []byte, pos uint64, key []byte) {
+    for i, b := range data {
+        data[i] = b + (byte(pos) + byte(i))
+    }
+}
+
+func SubposCipher(data []byte, pos uint64, key []byte) {
+    for i, b := range data {
+        data[i] = b + addInverse(byte(pos) + byte(i))
+    }
+}
+
+func RotateLeftCipher(data []byte, pos uint64, key []byte) {
+    for i, b := range data {
+        data[i] = bits.RotateLeft8(b, int(key[0] % 8))
+    }
+}
+
+func RotateRightCipher(data []byte, pos uint64, key []byte) {
+    for i, b := range data {
+        data[i] = bits.RotateLeft8(b, -int(key[0] % 8))
+    }
+}
+
+var substitutionOnce sync.Once
+var substitutions, inverseSubstitutions [256][256]byte
+
+// substitutionTables returns a permutation of the byte values for each key
+// byte, and their inverses. The shuffle is a fixed function of the key so
+// both ends agree on it.
+func substitutionTables() (*[256][256]byte, *[256][256]byte) {
+    substitutionOnce.Do(func() {
+        for key := range substitutions {
+            p := &substitutions[key]
+            for i := range p {
+                p[i] = byte(i)
+            }
+            // Fisher-Yates driven by xorshift32
+            state := uint32(key) * 2654435761 + 1
+            for i := 255; i > 0; i-- {
+                state ^= state << 13
+                state ^= state >> 17
+                state ^= state << 5
+                j := state % uint32(i + 1)
+                p[i], p[j] = p[j], p[i]
+            }
+            for i, b := range p {
+                inverseSubstitutions[key][b] = byte(i)
+            }
+        }
+    })
+    return &substitutions, &inverseSubstitutions
+}
+
+func SubstituteCipher(data []byte, pos uint64, key []byte) {
+    forward, _ := substitutionTables()
+    p := &forward[key[0]]
+    for i, b := range data {
+        data[i] = p[b]
+    }
+}
+
+func UnsubstituteCipher(data []byte, pos uint64, key []byte) {
+    _, inverse := substitutionTables()
+    p := &inverse[key[0]]
+    for i, b := range data {
+        data[i] = p[b]
+    }
+}
+
+// XorKeyCipher xors the stream with a repeating multi-byte key. It is its
+// own inverse.
+func XorKeyCipher(data []byte, pos uint64, key []byte) {
+    for i, b := range data {
+        data[i] = b ^ key[(pos + uint64(i)) % uint64(len(key))]
+    }
+}
+
+// Compiled ciphers only look at the position mod 256, so the key length has
+// to divide 256 for the key to line up the same way every time round.
+func validateXorKey(key []byte) error {
+    if len(key) == 0 || len(key) & (len(key) - 1) != 0 {
+        return fmt.Errorf("key length must be a power of two, got %d", len(key))
+    }
+    return nil
+}
+
+func init() {
+    Register(&CipherInfo{Op: ReverseBits, Name: "reversebits",
+        Forward: ReverseBitsCipher, Inverse: ReverseBitsCipher})
+    Register(&CipherInfo{Op: Xor, Name: "xor", Operands: 1,
+        Forward: XorCipher, Inverse: XorCipher})
+    Register(&CipherInfo{Op: Xorpos, Name: "xorpos", PositionDependent: true,
+        Forward: XorposCipher, Inverse: XorposCipher})
+    Register(&CipherInfo{Op: Add, Name: "add", Operands: 1,
+        Forward: AddCipher, Inverse: SubCipher})
+    Register(&CipherInfo{Op: Addpos, Name: "addpos", PositionDependent: true,
+        Forward: AddposCipher, Inverse: SubposCipher})
+    Register(&CipherInfo{Op: RotateLeft, Name: "rotl", Operands: 1,
+        Forward: RotateLeftCipher, Inverse: RotateRightCipher})
+    Register(&CipherInfo{Op: Substitute, Name: "subst", Operands: 1,
+        Forward: SubstituteCipher, Inverse: UnsubstituteCipher})
+    Register(&CipherInfo{Op: XorKey, Name: "xorkey", Operands: VariableOperands,
+        PositionDependent: true, ValidateKey: validateXorKey,
+        Forward: XorKeyCipher, Inverse: XorKeyCipher})
+}
+
+func isNoOpCipher(ciphers []Cipher) bool {
+    return Compile(ciphers).IsNoOp()
+}
@@ -0,0 +1,244 @@
+package isl
+
+import (
+    "bytes"
+    "errors"
+    "math/rand"
+    "reflect"
+    "testing"
+)
+
+type IsNoOpCase struct {
+    Data string
+    Result bool
+}
+var isNoOpCases []IsNoOpCase = []IsNoOpCase {
+    {"0100", false},
+    {"050500", false},
+    {"02010100", false},
+    {"027b050100", false},
+    {"020000", true},
+    {"02ab02ab00", true},
+    {"010100", true},
+    {"02a0020b02ab00", true},
+    // only a no-op when pos & 4 == 0
+    {"0304040304fc00", false},
+}
+
+func TestIsNoOp(t *testing.T) {
+    for _, c := range isNoOpCases {
+        buf := bytes.NewBuffer(decodeHex(t, c.Data))
+        ciphers, _, err := parseHandshake(buf, StandardOps)
+        if errors.Is(err, ErrNoOpCipher) {
+            if !c.Result {
+                t.Fatalf("Handshake rejected %s as a no-op", c.Data)
+            }
+            continue
+        } else if err != nil {
+            t.Fatalf("Bad case, failed to parse %s", c.Data)
+        }
+        res := isNoOpCipher(ciphers)
+        if res != c.Result {
+            t.Fatalf("Failed. Got %v, expected %v (%#v)", res, c.Result, c.Data)
+        }
+    }
+}
+
+func TestAddInverse(t *testing.T) {
+    for i := 0; i < 256; i++ {
+        inv := addInverse(byte(i))
+        res := byte(i) + inv
+        if res != 0 {
+            t.Fatalf("addInverse(%d) = %d is wrong (%d)", i, inv, res)
+        }
+    }
+}
+func TestMoreAddInverse(t *testing.T) {
+    for i := 0; i < 256; i++ {
+        inv := addInverse(byte(i))
+        res := byte(i) + inv
+        if res != 0 {
+            t.Fatalf("addInverse(%d) = %d is wrong (%d)", i, inv, res)
+        }
+        for b := 0; b < 256; b++ {
+            v := byte(b)
+            v += byte(i)
+            v += inv
+            if byte(b) != v {
+                t.Errorf("the inverse for %d doesn't work [orig] %d + [key]%d + [inv]%d == [not orig]%d", i, b, i, inv, v)
+            }
+        }
+    }
+}
+
+func TestCipherListSmoke(t *testing.T) {
+    //addpos,xor(20),reversebits,xorpos,reversebits,reversebits
+    ciphers := []Cipher {
+        Cipher{ Op: Addpos},
+        Cipher{ Op: Xor, Key: []byte{20}},
+        Cipher{ Op: ReverseBits},
+        Cipher{ Op: Xorpos},
+        Cipher{ Op: ReverseBits},
+        Cipher{ Op: ReverseBits},
+    }
+    testbuf := []byte("hello world the night is young\n")
+    pos := uint64(792)
+    buf := make([]byte, len(testbuf))
+    copy(buf, testbuf)
+    Crypt(ciphers, testbuf, pos, false)
+    Crypt(ciphers, testbuf, uint64(byte(pos)), true)
+    if !reflect.DeepEqual(testbuf, buf) {
+        t.Errorf("cipher does not work %v %v", buf, testbuf)
+    }
+}
+
+// Every registered cipher gets checked here, so new ones are covered as soon
+// as they're registered.
+func TestCiphersRoundTrip(t *testing.T) {
+    r := rand.New(rand.NewSource(32))
+    testbuf := []byte("hello world the night is young\n")
+    buf := make([]byte, len(testbuf))
+    for _, info := range RegisteredCiphers() {
+        for i := 0; i < 100; i++ {
+            c := randomCipher(r, info)
+            if err := c.Validate(); err != nil {
+                t.Fatalf("random %s was invalid: %s", info.Name, err)
+            }
+            pos := r.Uint64()
+            copy(buf, testbuf)
+            info.Forward(buf, pos, c.Key)
+            info.Inverse(buf, pos, c.Key)
+            if !reflect.DeepEqual(testbuf, buf) {
+                t.Fatalf("%s at %d does not round trip: %v", c, pos, buf)
+            }
+        }
+    }
+}
+
+func TestCiphersAreBijections(t *testing.T) {
+    r := rand.New(rand.NewSource(33))
+    for _, info := range RegisteredCiphers() {
+        for i := 0; i < 20; i++ {
+            c := randomCipher(r, info)
+            pos := r.Uint64()
+            seen := make(map[byte]bool)
+            buf := make([]byte, 1)
+            for b := 0; b < 256; b++ {
+                buf[0] = byte(b)
+                info.Forward(buf, pos, c.Key)
+                seen[buf[0]] = true
+            }
+            if len(seen) != 256 {
+                t.Fatalf("%s at %d maps 256 bytes onto %d", c, pos, len(seen))
+            }
+        }
+    }
+}
+
+func TestCipherSpecRoundTripRandom(t *testing.T) {
+    r := rand.New(rand.NewSource(34))
+    for i := 0; i < 200; i++ {
+        ciphers := randomCiphers(r, 1 + r.Intn(5))
+        parsed, err := ParseCipherSpec(FormatCipherSpec(ciphers))
+        if err != nil {
+            t.Fatalf("Error parsing %s: %s", FormatCipherSpec(ciphers), err)
+        }
+        if !reflect.DeepEqual(parsed, ciphers) {
+            t.Fatalf("%s parsed as %s", FormatCipherSpec(ciphers), FormatCipherSpec(parsed))
+        }
+    }
+}
+
+type ReverseBitsCase struct {
+    In, Out []byte
+}
+
+var reverseBitsCases []ReverseBitsCase = []ReverseBitsCase{
+    {[]byte{1, 1}, []byte{0x80, 0x80}},
+    {[]byte{2}, []byte{0x40}},
+}
+
+func TestReverseBits(t *testing.T) {
+    for _, b := range reverseBitsCases {
+        testbuf := make([]byte, len(b.In))
+        copy(testbuf, b.In)
+        ReverseBitsCipher(testbuf, 0, nil)
+        if !reflect.DeepEqual(testbuf, b.Out) {
+            t.Errorf("[%v] Not equal: %v | %v", b.In, testbuf, b.Out)
+        }
+    }
+}
+
+type CipherSpecCase struct {
+    Spec string
+    Wire string
+}
+
+var cipherSpecCases []CipherSpecCase = []CipherSpecCase {
+    {"reversebits", "0100"},
+    {"addpos,addpos", "050500"},
+    {"xor(0x01),reversebits", "02010100"},
+    {"xor(0x7b),addpos,reversebits", "027b050100"},
+    {"xorpos,add(0xff)", "0304ff00"},
+    {"rotl(0x03),subst(0xaa)", "060307aa00"},
+    {"xorkey(0x01020408),addpos", "08040102040805 00"},
+}
+
+func TestCipherSpecRoundTrip(t *testing.T) {
+    for _, c := range cipherSpecCases {
+        ciphers, err := ParseCipherSpec(c.Spec)
+        if err != nil {
+            t.Fatalf("Error parsing %s: %s", c.Spec, err)
+        }
+        if formatted := FormatCipherSpec(ciphers); formatted != c.Spec {
+            t.Errorf("Formatted %s as %s", c.Spec, formatted)
+        }
+        wire, err := MarshalCipherSpec(ciphers)
+        if err != nil {
+            t.Fatalf("Error marshalling %s: %s", c.Spec, err)
+        }
+        if !reflect.DeepEqual(wire, decodeHex(t, c.Wire)) {
+            t.Errorf("Marshalled %s as %x, expected %s", c.Spec, wire, c.Wire)
+        }
+        parsed, _, err := parseHandshake(bytes.NewBuffer(wire), AllOps())
+        if err != nil {
+            t.Fatalf("Error parsing handshake for %s: %s", c.Spec, err)
+        }
+        if formatted := FormatCipherSpec(parsed); formatted != c.Spec {
+            t.Errorf("Handshake for %s parsed as %s", c.Spec, formatted)
+        }
+    }
+}
+
+func TestParseCipherSpecKeys(t *testing.T) {
+    ciphers, err := ParseCipherSpec(" xor(123) , add(0x10)")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if ciphers[0].Key[0] != 123 || ciphers[1].Key[0] != 0x10 {
+        t.Fatalf("Bad keys %s", FormatCipherSpec(ciphers))
+    }
+}
+
+var badCipherSpecs []string = []string {
+    "",
+    "rot13",
+    "end",
+    "xor",
+    "xor(256)",
+    "xor(1",
+    "reversebits(1)",
+    "xorpos,,addpos",
+    "xorkey(0x010203)",
+    "xorkey(1)",
+    "rotl(0x0102)",
+}
+
+func TestParseBadCipherSpec(t *testing.T) {
+    for _, spec := range badCipherSpecs {
+        _, err := ParseCipherSpec(spec)
+        if !errors.Is(err, ErrBadCipherSpec) {
+            t.Errorf("Expected ErrBadCipherSpec for %q, got %v", spec, err)
+        }
+    }
+}
@@ -0,0 +1,94 @@
+package isl
+
+// Every cipher works on one byte at a time, and the only other thing that
+// can affect the output is the stream position, which they all only look at
+// mod 256. So any stack of them is just a lookup table per position.
+
+type CipherTable [256]byte
+
+// CompiledCipher is a cipher stack boiled down to lookup tables. Encrypting
+// or decrypting a byte is a single table lookup however long the stack is.
+type CompiledCipher struct {
+    // Either one table, if nothing in the stack cares about position, or one
+    // per stream position mod 256.
+    encrypt []CipherTable
+    decrypt []CipherTable
+}
+
+// Compile builds the tables for a stack of valid ciphers.
+func Compile(ciphers []Cipher) *CompiledCipher {
+    positional := false
+    for _, c := range ciphers {
+        if c.Info().PositionDependent {
+            positional = true
+        }
+    }
+    cc := &CompiledCipher{}
+    buf := make([]byte, 256)
+    if !positional {
+        for i := range buf {
+            buf[i] = byte(i)
+        }
+        Crypt(ciphers, buf, 0, false)
+        cc.encrypt = make([]CipherTable, 1)
+        copy(cc.encrypt[0][:], buf)
+    } else {
+        // Run each plaintext byte through positions 0-255 in one go, which
+        // fills in a column of every table at once.
+        cc.encrypt = make([]CipherTable, 256)
+        for b := 0; b < 256; b++ {
+            for i := range buf {
+                buf[i] = byte(b)
+            }
+            Crypt(ciphers, buf, 0, false)
+            for pos, out := range buf {
+                cc.encrypt[pos][b] = out
+            }
+        }
+    }
+    // Every cipher is a bijection, so the decryption tables are just the
+    // encryption tables inverted.
+    cc.decrypt = make([]CipherTable, len(cc.encrypt))
+    for pos := range cc.encrypt {
+        for b, out := range cc.encrypt[pos] {
+            cc.decrypt[pos][out] = byte(b)
+        }
+    }
+    return cc
+}
+
+func apply(tables []CipherTable, data []byte, pos uint64) {
+    if len(tables) == 1 {
+        t := &tables[0]
+        for i, b := range data {
+            data[i] = t[b]
+        }
+        return
+    }
+    for i, b := range data {
+        data[i] = tables[byte(pos + uint64(i))][b]
+    }
+}
+
+// Encrypt is equivalent to Crypt(ciphers, data, pos, false).
+func (cc *CompiledCipher) Encrypt(data []byte, pos uint64) {
+    apply(cc.encrypt, data, pos)
+}
+
+// Decrypt is equivalent to Crypt(ciphers, data, pos, true).
+func (cc *CompiledCipher) Decrypt(data []byte, pos uint64) {
+    apply(cc.decrypt, data, pos)
+}
+
+// IsNoOp reports whether the stack leaves every byte unchanged at every
+// position.
+func (cc *CompiledCipher) IsNoOp() bool {
+    for pos := range cc.encrypt {
+        for b, out := range cc.encrypt[pos] {
+            if byte(b) != out {
+                return false
+            }
+        }
+    }
+    return true
+}
@@ -0,0 +1,108 @@
+package isl
+
+import (
+    "fmt"
+    "math/rand"
+    "reflect"
+    "testing"
+)
+
+// randomCipher makes a valid cipher of the given kind with a random key.
+func randomCipher(r *rand.Rand, info *CipherInfo) Cipher {
+    n := info.Operands
+    if n == VariableOperands {
+        // only xorkey so far, which wants a power of two
+        n = 1 << r.Intn(4)
+    }
+    var key []byte
+    if n > 0 {
+        key = make([]byte, n)
+        r.Read(key)
+    }
+    return Cipher{Op: info.Op, Key: key}
+}
+
+func randomCiphers(r *rand.Rand, n int) []Cipher {
+    infos := RegisteredCiphers()
+    ciphers := make([]Cipher, n)
+    for i := range ciphers {
+        ciphers[i] = randomCipher(r, infos[r.Intn(len(infos))])
+    }
+    return ciphers
+}
+
+func TestCompiledMatchesCrypt(t *testing.T) {
+    r := rand.New(rand.NewSource(8))
+    testbuf := []byte("hello world the night is young\n")
+    for i := 0; i < 200; i++ {
+        ciphers := randomCiphers(r, 1 + r.Intn(10))
+        cc := Compile(ciphers)
+        pos := r.Uint64()
+        expected := append([]byte{}, testbuf...)
+        Crypt(ciphers, expected, pos, false)
+        buf := append([]byte{}, testbuf...)
+        cc.Encrypt(buf, pos)
+        if !reflect.DeepEqual(buf, expected) {
+            t.Fatalf("%s at %d: encrypted to %v, expected %v",
+                FormatCipherSpec(ciphers), pos, buf, expected)
+        }
+        cc.Decrypt(buf, pos)
+        if !reflect.DeepEqual(buf, testbuf) {
+            t.Fatalf("%s at %d: decrypted to %v", FormatCipherSpec(ciphers), pos, buf)
+        }
+    }
+}
+
+func TestCompiledTableCount(t *testing.T) {
+    ciphers, _ := ParseCipherSpec("xor(1),reversebits,add(7)")
+    if n := len(Compile(ciphers).encrypt); n != 1 {
+        t.Errorf("Position-independent stack compiled to %d tables", n)
+    }
+    ciphers, _ = ParseCipherSpec("xor(1),addpos")
+    if n := len(Compile(ciphers).encrypt); n != 256 {
+        t.Errorf("Position-dependent stack compiled to %d tables", n)
+    }
+}
+
+func benchmarkStack(n int) []Cipher {
+    return randomCiphers(rand.New(rand.NewSource(int64(n))), n)
+}
+
+var benchmarkStackLengths = []int{1, 5, 40}
+
+func BenchmarkCrypt(b *testing.B) {
+    for _, n := range benchmarkStackLengths {
+        ciphers := benchmarkStack(n)
+        buf := make([]byte, 4096)
+        b.Run(fmt.Sprintf("stack%d", n), func(b *testing.B) {
+            b.SetBytes(int64(len(buf)))
+            for i := 0; i < b.N; i++ {
+                Crypt(ciphers, buf, uint64(i), false)
+            }
+        })
+    }
+}
+
+func BenchmarkCompiled(b *testing.B) {
+    for _, n := range benchmarkStackLengths {
+        cc := Compile(benchmarkStack(n))
+        buf := make([]byte, 4096)
+        b.Run(fmt.Sprintf("stack%d", n), func(b *testing.B) {
+            b.SetBytes(int64(len(buf)))
+            for i := 0; i < b.N; i++ {
+                cc.Encrypt(buf, uint64(i))
+            }
+        })
+    }
+}
+
+func BenchmarkCompile(b *testing.B) {
+    for _, n := range benchmarkStackLengths {
+        ciphers := benchmarkStack(n)
+        b.Run(fmt.Sprintf("stack%d", n), func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                Compile(ciphers)
+            }
+        })
+    }
+}
@@ -0,0 +1,361 @@
+// Package isl implements the Insecure Sockets Layer from Protohackers problem
+// 8: a byte-at-a-time "encryption" layer over a stream connection. The client
+// sends a cipher spec, and from then on everything in both directions goes
+// through it.
+//
+// Listen and Dial return ordinary net.Listener and net.Conn values, so
+// anything that speaks TCP can speak ISL instead.
+package isl
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "sync"
+    "time"
+)
+
+// The client has this long to send its whole cipher spec.
+var HandshakeTimeout = 10 * time.Second
+
+// The problem statement promises specs are at most 80 bytes, End included.
+const MaxSpecLength = 80
+
+var ErrUnsupportedCipher = errors.New("unsupported cipher")
+var ErrNoOpCipher = errors.New("cipher spec is a no-op")
+var ErrNoCiphers = errors.New("no ciphers selected")
+var ErrSpecTooLong = errors.New("cipher spec too long")
+
+type Listener struct {
+    net.Listener
+    ops OpSet
+    negotiate bool
+}
+
+// Listen accepts ISL connections using the ciphers from the problem
+// statement, exactly as the problem describes.
+func Listen(network, address string) (net.Listener, error) {
+    tcpL, err := net.Listen(network, address)
+    if err != nil {
+        return nil, err
+    }
+    return &Listener {
+        Listener: tcpL,
+        ops: StandardOps,
+    }, nil
+}
+
+// ListenOps accepts ISL connections using any cipher in ops, and answers
+// clients that send Negotiate with the contents of ops.
+func ListenOps(network, address string, ops OpSet) (net.Listener, error) {
+    tcpL, err := net.Listen(network, address)
+    if err != nil {
+        return nil, err
+    }
+    return &Listener {
+        Listener: tcpL,
+        ops: ops,
+        negotiate: true,
+    }, nil
+}
+
+// ListenIf is Listen if secure is set and net.Listen if not, for servers
+// that can speak either.
+func ListenIf(secure bool, network, address string) (net.Listener, error) {
+    if secure {
+        return Listen(network, address)
+    }
+    return net.Listen(network, address)
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+    conn, err := l.Listener.Accept()
+    if err != nil {
+        return nil, err
+    }
+    return &Conn {
+        Conn: conn,
+        ops: l.ops,
+        negotiate: l.negotiate,
+    }, nil
+}
+
+// Close closes the listener.
+// Any blocked Accept operations will be unblocked and return errors.
+func (l *Listener) Close() error{
+    return l.Listener.Close()
+}
+
+// Addr returns the listener's network address.
+func (l *Listener) Addr() net.Addr{
+    return l.Listener.Addr()
+}
+
+// Dial connects to an ISL server, sends it spec and returns a Conn that
+// encrypts and decrypts with it.
+func Dial(network, address string, spec []Cipher) (net.Conn, error) {
+    conn, err := net.Dial(network, address)
+    if err != nil {
+        return nil, err
+    }
+    c, err := handshake(conn, spec)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return c, nil
+}
+
+// handshake sends spec over conn and wraps it.
+func handshake(conn net.Conn, spec []Cipher) (*Conn, error) {
+    if len(spec) == 0 {
+        return nil, ErrNoCiphers
+    }
+    wire, err := MarshalCipherSpec(spec)
+    if err != nil {
+        return nil, err
+    }
+    if len(wire) > MaxSpecLength {
+        return nil, ErrSpecTooLong
+    }
+    compiled := Compile(spec)
+    if compiled.IsNoOp() {
+        return nil, ErrNoOpCipher
+    }
+    _, err = conn.Write(wire)
+    if err != nil {
+        return nil, err
+    }
+    c := &Conn {
+        Conn: conn,
+        ciphers: spec,
+        compiled: compiled,
+    }
+    c.handshake.Do(func() {})
+    return c, nil
+}
+
+type Conn struct {
+    net.Conn
+    ciphers []Cipher
+    compiled *CompiledCipher
+    readpos, writepos uint64
+
+    // ops are the ciphers a client may pick. The zero value means
+    // StandardOps.
+    ops OpSet
+    // negotiate is set if the client may ask for ops first.
+    negotiate bool
+
+    // handshake guards ciphers, compiled and handshakeErr, since the first Read and
+    // the first Write can race to do it.
+    handshake sync.Once
+    handshakeErr error
+}
+
+// EnsureHandshake reads the client's cipher spec if that hasn't happened yet.
+// Only the first call does any work; the rest wait for it and return the same
+// result. Read and Write call it for you.
+//
+// The handshake sets its own read deadline and clears it afterwards, so any
+// read deadline set before the handshake is lost.
+func (c *Conn) EnsureHandshake() error {
+    c.handshake.Do(func() {
+        if len(c.ciphers) != 0 {
+            c.compiled = Compile(c.ciphers)
+            return
+        }
+        c.Conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+        ciphers, compiled, err := c.readHandshake()
+        if err != nil {
+            log.Println("Error handshaking with client", c.RemoteAddr(), err)
+            c.Close()
+            c.handshakeErr = err
+            return
+        }
+        c.Conn.SetReadDeadline(time.Time{})
+        c.ciphers = ciphers
+        c.compiled = compiled
+        log.Println("Client handshake success", c.RemoteAddr(), FormatCipherSpec(c.ciphers))
+    })
+    return c.handshakeErr
+}
+
+func (c *Conn) readHandshake() ([]Cipher, *CompiledCipher, error) {
+    ops := c.ops
+    if ops == (OpSet{}) {
+        ops = StandardOps
+    }
+    if !c.negotiate {
+        return parseHandshake(c.Conn, ops)
+    }
+    first := make([]byte, 1)
+    if _, err := io.ReadFull(c.Conn, first); err != nil {
+        return nil, nil, err
+    }
+    if first[0] != Negotiate {
+        return parseHandshake(io.MultiReader(bytes.NewReader(first), c.Conn), ops)
+    }
+    if err := writeAdvertisement(c.Conn, ops); err != nil {
+        return nil, nil, err
+    }
+    return parseHandshake(c.Conn, ops)
+}
+
+// specReader hands out the bytes of a cipher spec one at a time, so we never
+// read past the End, and enforces MaxSpecLength.
+type specReader struct {
+    r io.Reader
+    length int
+    buf [1]byte
+}
+
+func (s *specReader) next() (byte, error) {
+    if s.length >= MaxSpecLength {
+        return 0, ErrSpecTooLong
+    }
+    s.length++
+    _, err := io.ReadFull(s.r, s.buf[:])
+    return s.buf[0], err
+}
+
+// parseHandshake reads a cipher spec, and returns it along with its compiled
+// form, which it needs anyway to reject no-op specs.
+func parseHandshake(r io.Reader, ops OpSet) ([]Cipher, *CompiledCipher, error) {
+    ciphers := make([]Cipher, 0)
+    sr := &specReader{r: r}
+    for {
+        op, err := sr.next()
+        if err != nil {
+            return nil, nil, err
+        }
+        if op == End {
+            break
+        }
+        info := LookupCipher(op)
+        if info == nil || !ops.Has(op) {
+            return nil, nil, fmt.Errorf("%w %d", ErrUnsupportedCipher, op)
+        }
+        operands := info.Operands
+        if operands == VariableOperands {
+            n, err := sr.next()
+            if err != nil {
+                return nil, nil, err
+            }
+            operands = int(n)
+        }
+        var key []byte
+        if operands > 0 {
+            key = make([]byte, operands)
+        }
+        for i := range key {
+            key[i], err = sr.next()
+            if err != nil {
+                return nil, nil, err
+            }
+        }
+        c := Cipher{Op: op, Key: key}
+        if err := c.Validate(); err != nil {
+            return nil, nil, err
+        }
+        ciphers = append(ciphers, c)
+    }
+    if len(ciphers) == 0 {
+        return nil, nil, ErrNoCiphers
+    }
+    compiled := Compile(ciphers)
+    if compiled.IsNoOp() {
+        return nil, nil, ErrNoOpCipher
+    }
+    return ciphers, compiled, nil
+}
+
+// Read reads data from the connection.
+// Read can be made to time out and return an error after a fixed
+// time limit; see SetDeadline and SetReadDeadline.
+func (c *Conn) Read (b []byte) (n int, err error) {
+    err = c.EnsureHandshake()
+    if err != nil {
+        return 0, err
+    }
+    n, err = c.Conn.Read(b)
+    c.compiled.Decrypt(b[0:n], c.readpos)
+    c.readpos += uint64(n)
+    return n, err
+}
+
+// Write writes data to the connection.
+// Write can be made to time out and return an error after a fixed
+// time limit; see SetDeadline and SetWriteDeadline.
+func (c *Conn) Write(b []byte) (n int, err error) {
+    err = c.EnsureHandshake()
+    if err != nil {
+        return 0, err
+    }
+    tmp := make([]byte, len(b))
+    copy(tmp, b)
+    c.compiled.Encrypt(tmp, c.writepos)
+    c.writepos += uint64(len(tmp))
+    return c.Conn.Write(tmp)
+}
+
+// Close closes the connection.
+// Any blocked Read or Write operations will be unblocked and return errors.
+func (c *Conn) Close() error {
+    return c.Conn.Close()
+}
+
+// LocalAddr returns the local network address, if known.
+func (c *Conn) LocalAddr() net.Addr {
+    return c.Conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address, if known.
+func (c *Conn) RemoteAddr() net.Addr {
+    return c.Conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines associated
+// with the connection. It is equivalent to calling both
+// SetReadDeadline and SetWriteDeadline.
+//
+// A deadline is an absolute time after which I/O operations
+// fail instead of blocking. The deadline applies to all future
+// and pending I/O, not just the immediately following call to
+// Read or Write. After a deadline has been exceeded, the
+// connection can be refreshed by setting a deadline in the future.
+//
+// If the deadline is exceeded a call to Read or Write or to other
+// I/O methods will return an error that wraps os.ErrDeadlineExceeded.
+// This can be tested using errors.Is(err, os.ErrDeadlineExceeded).
+// The error's Timeout method will return true, but note that there
+// are other possible errors for which the Timeout method will
+// return true even if the deadline has not been exceeded.
+//
+// An idle timeout can be implemented by repeatedly extending
+// the deadline after successful Read or Write calls.
+//
+// A zero value for t means I/O operations will not time out.
+func (c *Conn) SetDeadline(t time.Time) error {
+    return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future Read calls
+// and any currently-blocked Read call.
+// A zero value for t means Read will not time out.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+    return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for future Write calls
+// and any currently-blocked Write call.
+// Even if write times out, it may return n > 0, indicating that
+// some of the data was successfully written.
+// A zero value for t means Write will not time out.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+    return c.Conn.SetWriteDeadline(t)
+}
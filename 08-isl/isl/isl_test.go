@@ -0,0 +1,357 @@
+package isl
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/hex"
+    "errors"
+    "io"
+    "net"
+    "os"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func decodeHex(t *testing.T, hexdata string) []byte {
+	hexdata = strings.ReplaceAll(hexdata, " ", "")
+	data, err := hex.DecodeString(hexdata)
+	if err != nil {
+            t.Fatalf("error decoding hex")
+	}
+	return data
+}
+type ParseCipherCase struct {
+    Data string
+    Result []Cipher
+}
+
+var parseCipherCases []ParseCipherCase = []ParseCipherCase {
+    {"0100", []Cipher{
+        Cipher{Op: ReverseBits},
+    }},
+    {"050500", []Cipher{
+        Cipher{Op: Addpos},
+        Cipher{Op: Addpos},
+    }},
+    {"02010100", []Cipher{
+        Cipher{Op: Xor, Key: []byte{1}},
+        Cipher{Op: ReverseBits},
+    }},
+    {"027b050100", []Cipher{
+        Cipher{Op: Xor, Key: []byte{123}},
+        Cipher{Op: Addpos},
+        Cipher{Op: ReverseBits},
+    }},
+    {"0603 07aa 08 04 01020408 00", []Cipher{
+        Cipher{Op: RotateLeft, Key: []byte{3}},
+        Cipher{Op: Substitute, Key: []byte{0xaa}},
+        Cipher{Op: XorKey, Key: []byte{1, 2, 4, 8}},
+    }},
+}
+
+func minInt(a, b int) int {
+    if a < b {
+        return a
+    } else {
+        return b
+    }
+}
+
+func TestParseHandshake(t *testing.T) {
+    for _, c := range parseCipherCases {
+        buf := bytes.NewBuffer(decodeHex(t, c.Data))
+        ciphers, _, err := parseHandshake(buf, AllOps())
+        if err != nil {
+            t.Fatalf("Error parsing %s %#v", err, c)
+        }
+        good := true
+        if len(ciphers) != len(c.Result) {
+            good = false
+        }
+        for i := 0; i < minInt(len(ciphers), len(c.Result)); i++ {
+            if ciphers[i].Op != c.Result[i].Op {
+                good = false
+                break
+            }
+            if !bytes.Equal(ciphers[i].Key, c.Result[i].Key) {
+                good = false
+                break
+            }
+        }
+        if !good {
+            t.Fatalf("Bad: \n\tgot      %#v,\n\texpected %#v", ciphers, c.Result)
+        }
+    }
+}
+
+type FakeConn struct {
+    buf bytes.Buffer
+}
+
+func (fc *FakeConn) Close() error {
+    return nil
+}
+func (fc *FakeConn) LocalAddr() net.Addr {
+    panic("buzz off")
+}
+func (fc *FakeConn) RemoteAddr() net.Addr {
+    panic("buzz off")
+}
+func (fc *FakeConn) Read(buf []byte) (int, error) {
+    return fc.buf.Read(buf)
+}
+func (fc *FakeConn) Write(buf []byte) (int, error) {
+    return fc.buf.Write(buf)
+}
+func (fc *FakeConn) SetDeadline(t time.Time) error {
+    panic("buzz off")
+}
+func (fc *FakeConn) SetReadDeadline(t time.Time) error {
+    panic("buzz off")
+}
+func (fc *FakeConn) SetWriteDeadline(t time.Time) error {
+    panic("buzz off")
+}
+
+func TestSession(t *testing.T) {
+    buf := bytes.Buffer{}
+    buf.Write(decodeHex(t, "02 7b 05 01 00"))
+    parsed, _, err := parseHandshake(&buf, StandardOps)
+    if err != nil {
+        t.Fatalf("Error parsing handshake %s", err)
+    }
+    buf.Reset()
+    buf.Write(decodeHex(t, "f2 20 ba 44 18 84 ba aa d0 26 44 a4 a8 7e"))
+    fc := &FakeConn {
+        buf,
+    }
+    c := &Conn {
+        Conn: fc,
+        ciphers: parsed,
+    }
+
+    data, _ := io.ReadAll(c)
+    should_be := []byte("4x dog,5x car\n")
+    if !reflect.DeepEqual(data, should_be) {
+        t.Fatal(hex.EncodeToString(data), "||", string(data))
+    }
+}
+
+func TestDial(t *testing.T) {
+    l, err := Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer l.Close()
+    go func() {
+        conn, err := l.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        io.Copy(conn, conn)
+    }()
+
+    spec, err := ParseCipherSpec("xor(0x7b),addpos,reversebits")
+    if err != nil {
+        t.Fatal(err)
+    }
+    c, err := Dial("tcp", l.Addr().String(), spec)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    io.WriteString(c, "4x dog,5x car\n")
+    io.WriteString(c, "3x rat,2x cat\n")
+    sc := bufio.NewScanner(c)
+    for _, expected := range []string{"4x dog,5x car", "3x rat,2x cat"} {
+        if !sc.Scan() {
+            t.Fatalf("failed to scan: %s", sc.Err())
+        }
+        if sc.Text() != expected {
+            t.Fatalf("got %s, expected %s", sc.Text(), expected)
+        }
+    }
+}
+
+func TestDialNoOp(t *testing.T) {
+    spec, err := ParseCipherSpec("xor(0xab),xor(0xab)")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = Dial("tcp", "127.0.0.1:1", spec)
+    if err == nil {
+        t.Fatal("Dial accepted a no-op cipher spec")
+    }
+}
+
+type HandshakeErrorCase struct {
+    Data string
+    Err error
+}
+
+var handshakeErrorCases []HandshakeErrorCase = []HandshakeErrorCase {
+    {"00", ErrNoCiphers},
+    {"06", ErrUnsupportedCipher},
+    {"ff", ErrUnsupportedCipher},
+    {"010100", ErrNoOpCipher},
+    {"0101", io.EOF},
+    {"02", io.EOF},
+    {strings.Repeat("05", MaxSpecLength), ErrSpecTooLong},
+    // extensions aren't on by default
+    {"060100", ErrUnsupportedCipher},
+}
+
+func TestHandshakeErrors(t *testing.T) {
+    for _, c := range handshakeErrorCases {
+        _, _, err := parseHandshake(bytes.NewBuffer(decodeHex(t, c.Data)), StandardOps)
+        if !errors.Is(err, c.Err) {
+            t.Errorf("Expected %v for %s, got %v", c.Err, c.Data, err)
+        }
+    }
+}
+
+func TestHandshakeMaxLength(t *testing.T) {
+    spec := strings.Repeat("05", MaxSpecLength - 1) + "00"
+    ciphers, _, err := parseHandshake(bytes.NewBuffer(decodeHex(t, spec)), StandardOps)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(ciphers) != MaxSpecLength - 1 {
+        t.Fatalf("Expected %d ciphers, got %d", MaxSpecLength - 1, len(ciphers))
+    }
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+    oldTimeout := HandshakeTimeout
+    HandshakeTimeout = 50 * time.Millisecond
+    defer func() { HandshakeTimeout = oldTimeout }()
+
+    server, client := net.Pipe()
+    defer client.Close()
+    c := &Conn {
+        Conn: server,
+    }
+    // start a spec but never finish it
+    go client.Write([]byte{Xor})
+    _, err := c.Read(make([]byte, 10))
+    if !errors.Is(err, os.ErrDeadlineExceeded) {
+        t.Fatalf("Expected a deadline error, got %v", err)
+    }
+}
+
+func TestConcurrentHandshake(t *testing.T) {
+    server, client := net.Pipe()
+    defer client.Close()
+    c := &Conn {
+        Conn: server,
+    }
+    errs := make(chan error)
+    go func() {
+        _, err := c.Write([]byte("4x dog\n"))
+        errs <- err
+    }()
+    go func() {
+        _, err := c.Read(make([]byte, 10))
+        errs <- err
+    }()
+    client.Write(decodeHex(t, "027b050100"))
+    reply := make([]byte, 7)
+    if _, err := io.ReadFull(client, reply); err != nil {
+        t.Fatal(err)
+    }
+    client.Write(reply)
+    for i := 0; i < 2; i++ {
+        if err := <-errs; err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func echoServer(l net.Listener) {
+    go func() {
+        for {
+            conn, err := l.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer conn.Close()
+                io.Copy(conn, conn)
+            }()
+        }
+    }()
+}
+
+func TestDialNegotiated(t *testing.T) {
+    ops := NewOpSet(Xor, RotateLeft, XorKey)
+    l, err := ListenOps("tcp", "127.0.0.1:0", ops)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer l.Close()
+    echoServer(l)
+
+    var advertised OpSet
+    c, err := DialNegotiated("tcp", l.Addr().String(), func(supported OpSet) ([]Cipher, error) {
+        advertised = supported
+        return ParseCipherSpec("rotl(3),xorkey(0x0102)")
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    if advertised != ops {
+        t.Fatalf("Server advertised %v, expected %v", advertised.Ops(), ops.Ops())
+    }
+    io.WriteString(c, "hello\n")
+    sc := bufio.NewScanner(c)
+    if !sc.Scan() || sc.Text() != "hello" {
+        t.Fatalf("Bad echo %q %v", sc.Text(), sc.Err())
+    }
+
+    // plain clients still work too
+    spec, _ := ParseCipherSpec("xor(5)")
+    c2, err := Dial("tcp", l.Addr().String(), spec)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c2.Close()
+    io.WriteString(c2, "world\n")
+    sc = bufio.NewScanner(c2)
+    if !sc.Scan() || sc.Text() != "world" {
+        t.Fatalf("Bad echo %q %v", sc.Text(), sc.Err())
+    }
+}
+
+func TestDialNegotiatedUnsupported(t *testing.T) {
+    l, err := ListenOps("tcp", "127.0.0.1:0", StandardOps)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer l.Close()
+    echoServer(l)
+    _, err = DialNegotiated("tcp", l.Addr().String(), func(supported OpSet) ([]Cipher, error) {
+        return ParseCipherSpec("subst(1)")
+    })
+    if !errors.Is(err, ErrUnsupportedCipher) {
+        t.Fatalf("Expected ErrUnsupportedCipher, got %v", err)
+    }
+}
+
+func TestStandardListenerRejectsNegotiate(t *testing.T) {
+    l, err := Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer l.Close()
+    echoServer(l)
+    _, err = DialNegotiated("tcp", l.Addr().String(), func(supported OpSet) ([]Cipher, error) {
+        return ParseCipherSpec("xor(1)")
+    })
+    if err == nil {
+        t.Fatal("Standard listener answered a negotiation")
+    }
+}
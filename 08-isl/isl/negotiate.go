@@ -0,0 +1,114 @@
+package isl
+
+import (
+    "fmt"
+    "io"
+    "net"
+    "time"
+)
+
+// Negotiate is sent by a client in place of the first byte of its cipher
+// spec to ask which opcodes the server supports. The server replies, in the
+// clear, with a count byte followed by that many opcodes, and then reads the
+// spec as usual.
+//
+// It's an extension, so only Listeners from ListenOps understand it.
+const Negotiate = 0xff
+
+// OpSet is a set of cipher opcodes.
+type OpSet [4]uint64
+
+func NewOpSet(ops ...byte) OpSet {
+    var s OpSet
+    for _, op := range ops {
+        s.Add(op)
+    }
+    return s
+}
+
+func (s *OpSet) Add(op byte) {
+    s[op / 64] |= 1 << (op % 64)
+}
+
+func (s OpSet) Has(op byte) bool {
+    return s[op / 64] & (1 << (op % 64)) != 0
+}
+
+// Ops lists the opcodes in s in order.
+func (s OpSet) Ops() []byte {
+    ops := []byte{}
+    for op := 0; op < 256; op++ {
+        if s.Has(byte(op)) {
+            ops = append(ops, byte(op))
+        }
+    }
+    return ops
+}
+
+// StandardOps are the ciphers from the problem statement. Servers from Listen
+// accept only these.
+var StandardOps = NewOpSet(ReverseBits, Xor, Xorpos, Add, Addpos)
+
+// AllOps returns the set of every registered cipher.
+func AllOps() OpSet {
+    var s OpSet
+    for _, info := range RegisteredCiphers() {
+        s.Add(info.Op)
+    }
+    return s
+}
+
+func writeAdvertisement(w io.Writer, ops OpSet) error {
+    list := ops.Ops()
+    _, err := w.Write(append([]byte{byte(len(list))}, list...))
+    return err
+}
+
+func readAdvertisement(r io.Reader) (OpSet, error) {
+    count := make([]byte, 1)
+    if _, err := io.ReadFull(r, count); err != nil {
+        return OpSet{}, err
+    }
+    list := make([]byte, count[0])
+    if _, err := io.ReadFull(r, list); err != nil {
+        return OpSet{}, err
+    }
+    return NewOpSet(list...), nil
+}
+
+// DialNegotiated connects to a server from ListenOps, asks which ciphers it
+// supports, and lets choose pick a spec from them.
+func DialNegotiated(network, address string, choose func(supported OpSet) ([]Cipher, error)) (net.Conn, error) {
+    conn, err := net.Dial(network, address)
+    if err != nil {
+        return nil, err
+    }
+    c, err := negotiate(conn, choose)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return c, nil
+}
+
+func negotiate(conn net.Conn, choose func(supported OpSet) ([]Cipher, error)) (net.Conn, error) {
+    if _, err := conn.Write([]byte{Negotiate}); err != nil {
+        return nil, err
+    }
+    conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+    supported, err := readAdvertisement(conn)
+    if err != nil {
+        return nil, err
+    }
+    conn.SetReadDeadline(time.Time{})
+    spec, err := choose(supported)
+    if err != nil {
+        return nil, err
+    }
+    for _, c := range spec {
+        if !supported.Has(c.Op) {
+            return nil, fmt.Errorf("%w: server doesn't support %s", ErrUnsupportedCipher, c)
+        }
+    }
+    return handshake(conn, spec)
+}
@@ -0,0 +1,76 @@
+package isl
+
+import (
+    "fmt"
+)
+
+// VariableOperands as CipherInfo.Operands means the byte after the opcode
+// says how many key bytes follow.
+const VariableOperands = -1
+
+// CipherFunc transforms data in place. pos is the stream position of data[0]
+// and key is the cipher's key bytes from the spec (nil if it takes none).
+type CipherFunc func(data []byte, pos uint64, key []byte)
+
+// CipherInfo describes one kind of cipher. Register one to make it usable in
+// specs.
+type CipherInfo struct {
+    Op byte
+    Name string
+    // Operands is how many key bytes follow the opcode on the wire, or
+    // VariableOperands.
+    Operands int
+    // PositionDependent is set if the output depends on the stream position
+    // (mod 256, which is all compiled ciphers look at).
+    PositionDependent bool
+    Forward CipherFunc
+    Inverse CipherFunc
+    // ValidateKey, if set, rejects keys the cipher can't use.
+    ValidateKey func(key []byte) error
+}
+
+var registry [256]*CipherInfo
+
+// Register adds a cipher. It panics if the opcode or name is already taken or
+// is reserved, so it's meant to be called from init.
+func Register(info *CipherInfo) {
+    if info.Op == End || info.Op == Negotiate {
+        panic(fmt.Sprintf("isl: opcode %d is reserved", info.Op))
+    }
+    if registry[info.Op] != nil {
+        panic(fmt.Sprintf("isl: opcode %d registered twice", info.Op))
+    }
+    if LookupCipherName(info.Name) != nil {
+        panic(fmt.Sprintf("isl: cipher name %q registered twice", info.Name))
+    }
+    if info.Forward == nil || info.Inverse == nil {
+        panic(fmt.Sprintf("isl: cipher %q needs Forward and Inverse", info.Name))
+    }
+    registry[info.Op] = info
+}
+
+// LookupCipher returns the cipher registered for op, or nil.
+func LookupCipher(op byte) *CipherInfo {
+    return registry[op]
+}
+
+// LookupCipherName returns the cipher registered under name, or nil.
+func LookupCipherName(name string) *CipherInfo {
+    for _, info := range registry {
+        if info != nil && info.Name == name {
+            return info
+        }
+    }
+    return nil
+}
+
+// RegisteredCiphers returns every registered cipher in opcode order.
+func RegisteredCiphers() []*CipherInfo {
+    infos := []*CipherInfo{}
+    for _, info := range registry {
+        if info != nil {
+            infos = append(infos, info)
+        }
+    }
+    return infos
+}
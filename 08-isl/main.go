@@ -10,6 +10,8 @@ import (
     "strconv"
     "strings"
     "regexp"
+
+    "z10f.com/golang/protohackers/08/isl"
 )
 
 type Toy struct {
@@ -68,7 +70,7 @@ func handleConn(c net.Conn) {
 }
 
 func main() {
-    l, err := Listen("tcp", ":1337")
+    l, err := isl.Listen("tcp", ":1337")
     if err != nil {
         log.Fatal(err)
     }
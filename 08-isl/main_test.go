@@ -5,6 +5,8 @@ import (
     "io"
     "net"
     "testing"
+
+    "z10f.com/golang/protohackers/08/isl"
 )
 
 type ResponseCase struct {
@@ -76,3 +78,39 @@ func TestDoubleConn(t *testing.T) {
             t.Fatalf("got unexpected result(2) %s", sc.Text())
         }
 }
+
+func TestOverISL(t *testing.T) {
+    l, err := isl.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer l.Close()
+    go func() {
+        conn, err := l.Accept()
+        if err != nil {
+            return
+        }
+        handleConn(conn)
+    }()
+
+    spec, err := isl.ParseCipherSpec("xor(0x7b),addpos,reversebits")
+    if err != nil {
+        t.Fatal(err)
+    }
+    c, err := isl.Dial("tcp", l.Addr().String(), spec)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    io.WriteString(c, "4x dog,5x car\n")
+    io.WriteString(c, "3x rat,2x cat\n")
+    sc := bufio.NewScanner(c)
+    for _, expected := range []string{"5x car", "3x rat"} {
+        if !sc.Scan() {
+            t.Fatalf("failed to scan: %s", sc.Err())
+        }
+        if sc.Text() != expected {
+            t.Fatalf("got %s, expected %s", sc.Text(), expected)
+        }
+    }
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const BLOB_DIR = "blobs"
+const TREE_DIR = "tree"
+
+// Names in the tree get a suffix saying what they are. Repository names
+// can't contain '~', so these never clash with each other, and even "." and
+// ".." are safe to use on disk.
+const DIR_SUFFIX = "~d"
+const FILE_SUFFIX = "~f"
+
+// TEMP_PATTERN names files that are still being written. Anything matching
+// it at startup was left by a crash, and is removed.
+const TEMP_PATTERN = "*.tmp"
+
+var ErrBadTreeName = errors.New("unexpected name in tree")
+
+// INDEX_LINE is the length of each line of an index: a hex SHA-256 hash and
+// a newline.
+const INDEX_LINE = sha256.Size*2 + 1
+
+// DiskStorage keeps revisions on disk under dir. Each revision's contents
+// are a blob in blobs/, named by its SHA-256 hash, so identical contents are
+// only stored once. tree/ mirrors the repository's directories, and has an
+// index for each repository file listing the hashes of its revisions, one
+// per line.
+//
+// Blobs are written to a temporary name, synced and then renamed into place,
+// and a blob is always written before the index line that refers to it.
+// Indexes are only ever appended to. So a crash can only leave temporary
+// files, unreferenced blobs or a half-written last index line behind, and
+// OpenDiskStorage cleans all of those up.
+//
+// The indexes are read once, when the storage is opened, and kept in memory
+// from then on.
+type DiskStorage struct {
+	dir string
+	// files has the hashes of each file's revisions, keyed by the file's
+	// path joined with "/".
+	files map[string][]string
+}
+
+// OpenDiskStorage opens the storage in dir, creating it if need be, and
+// cleans up after any crash.
+func OpenDiskStorage(dir string) (*DiskStorage, error) {
+	d := &DiskStorage{dir: dir, files: make(map[string][]string)}
+	for _, sub := range []string{BLOB_DIR, TREE_DIR} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.recover(); err != nil {
+		return nil, err
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// recover removes temporary files left by writes that never finished.
+func (d *DiskStorage) recover() error {
+	return filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if matched, _ := filepath.Match(TEMP_PATTERN, entry.Name()); matched && !entry.IsDir() {
+			log.Println("Removing unfinished write", path)
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+func (d *DiskStorage) blobPath(hash string) string {
+	return filepath.Join(d.dir, BLOB_DIR, hash[:2], hash)
+}
+
+func (d *DiskStorage) indexPath(path []string) string {
+	parts := []string{d.dir, TREE_DIR}
+	for _, comp := range path[:len(path)-1] {
+		parts = append(parts, comp+DIR_SUFFIX)
+	}
+	parts = append(parts, path[len(path)-1]+FILE_SUFFIX)
+	return filepath.Join(parts...)
+}
+
+// load reads every index in the tree into d.files.
+func (d *DiskStorage) load() error {
+	root := filepath.Join(d.dir, TREE_DIR)
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		if entry.IsDir() {
+			if !strings.HasSuffix(entry.Name(), DIR_SUFFIX) {
+				return ErrBadTreeName
+			}
+			return nil
+		}
+		if !strings.HasSuffix(entry.Name(), FILE_SUFFIX) {
+			return ErrBadTreeName
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		components := strings.Split(filepath.ToSlash(rel), "/")
+		for i, comp := range components[:len(components)-1] {
+			components[i] = strings.TrimSuffix(comp, DIR_SUFFIX)
+		}
+		last := len(components) - 1
+		components[last] = strings.TrimSuffix(components[last], FILE_SUFFIX)
+		hashes, err := d.readIndex(path)
+		if err != nil {
+			return err
+		}
+		d.files[strings.Join(components, "/")] = hashes
+		return nil
+	})
+}
+
+// readIndex returns the hashes of a file's revisions. If a blob is missing,
+// or a line is bad or cut short, the revisions from there on are left out
+// and the index is rewritten without them, so a file never has a revision
+// that can't be read and later appends start on a fresh line. Any other
+// trouble checking a blob is returned, leaving the index as it is.
+func (d *DiskStorage) readIndex(index string) ([]string, error) {
+	data, err := os.ReadFile(index)
+	if err != nil {
+		return nil, err
+	}
+	// the last element is whatever follows the last newline, which is
+	// nothing unless a crash cut an append short
+	lines := strings.Split(string(data), "\n")
+	complete, rest := lines[:len(lines)-1], lines[len(lines)-1]
+	if rest != "" {
+		log.Printf("Unfinished revision in %s, ignoring it\n", index)
+	}
+	hashes := []string{}
+	for _, hash := range complete {
+		if !validHash(hash) {
+			log.Printf("Bad hash %q in %s, ignoring later revisions\n", hash, index)
+			break
+		}
+		if _, err := os.Stat(d.blobPath(hash)); errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Missing blob %s in %s, ignoring later revisions\n", hash, index)
+			break
+		} else if err != nil {
+			// the blob may well be there, so leave the index alone
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) < len(complete) || rest != "" {
+		var kept strings.Builder
+		for _, hash := range hashes {
+			kept.WriteString(hash + "\n")
+		}
+		if err := writeAtomic(index, []byte(kept.String())); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+func validHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+func (d *DiskStorage) Files() ([]StoredFile, error) {
+	files := []StoredFile{}
+	for name, hashes := range d.files {
+		if len(hashes) > 0 {
+			files = append(files, StoredFile{Path: strings.Split(name, "/"), Revisions: Revision(len(hashes))})
+		}
+	}
+	return files, nil
+}
+
+func (d *DiskStorage) Read(path []string, rev Revision) ([]byte, error) {
+	hashes := d.files[strings.Join(path, "/")]
+	if rev < 1 || int(rev) > len(hashes) {
+		return nil, ErrNoRevision
+	}
+	return os.ReadFile(d.blobPath(hashes[rev-1]))
+}
+
+func (d *DiskStorage) Append(path []string, data []byte) (Revision, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if _, err := os.Stat(d.blobPath(hash)); errors.Is(err, os.ErrNotExist) {
+		if err := writeAtomic(d.blobPath(hash), data); err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	name := strings.Join(path, "/")
+	hashes := d.files[name]
+	if err := d.appendIndex(d.indexPath(path), hash, len(hashes)); err != nil {
+		return 0, err
+	}
+	d.files[name] = append(hashes, hash)
+	return Revision(len(hashes) + 1), nil
+}
+
+// appendIndex adds hash to the end of an index that has count lines. If the
+// append fails, the index is cut back to count lines, so a torn line can't
+// run into the next one.
+func (d *DiskStorage) appendIndex(index, hash string, count int) error {
+	dir := filepath.Dir(index)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(index, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(hash + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(int64(count) * INDEX_LINE)
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if count == 0 {
+		// the index is new, so make its name durable too
+		syncDir(dir)
+	}
+	return nil
+}
+
+// writeAtomic replaces path with data, so that after a crash it has either
+// its old contents or all of the new ones.
+func writeAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, TEMP_PATTERN)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// make the rename itself durable
+	syncDir(dir)
+	return nil
+}
+
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		f.Sync()
+		f.Close()
+	}
+}
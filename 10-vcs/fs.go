@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"errors"
 	"strings"
+	"sync"
 )
 
 var ErrEmptyComponent = errors.New("empty component in path")
@@ -19,16 +20,21 @@ var ErrInvalidText = errors.New("found non-text character")
 
 type Revision int
 
+// Repository keeps the directory tree in memory, and the revisions
+// themselves in its Storage.
 type Repository struct {
-	root *Directory
+	lock    sync.Mutex
+	storage Storage
+	root    *Directory
 }
 
 type File struct {
-	revisions [][]byte
+	path      []string
+	revisions Revision
 }
 
 func (f *File) currentRevision() Revision {
-	return Revision(len(f.revisions))
+	return f.revisions
 }
 
 type Directory struct {
@@ -36,12 +42,34 @@ type Directory struct {
 	files   map[string]*File
 }
 
+// NewRepository makes an empty repository that only lives in memory.
 func NewRepository() *Repository {
 	return &Repository{
-		root: newDirectory(),
+		storage: NewMemoryStorage(),
+		root:    newDirectory(),
 	}
 }
 
+// OpenRepository makes a repository of whatever is already in storage.
+func OpenRepository(storage Storage) (*Repository, error) {
+	r := &Repository{
+		storage: storage,
+		root:    newDirectory(),
+	}
+	files, err := storage.Files()
+	if err != nil {
+		return nil, err
+	}
+	for _, stored := range files {
+		file, err := r.lookupFile(stored.Path, true)
+		if err != nil {
+			return nil, err
+		}
+		file.revisions = stored.Revisions
+	}
+	return r, nil
+}
+
 func (r *Repository) lookupDirectory(components []string, create bool) (*Directory, error) {
 	walk := r.root
 	for _, comp := range components {
@@ -72,7 +100,7 @@ func (r *Repository) lookupFile(components []string, create bool) (*File, error)
 	if !ok && !create {
 		return nil, ErrNotFound
 	} else if !ok {
-		file = &File{}
+		file = &File{path: components}
 		dir.files[last] = file
 	}
 	return file, nil
@@ -149,6 +177,8 @@ type ListEntry struct {
 }
 
 func (r *Repository) List(path string) ([]*ListEntry, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	parsed, err := parseDirectory(path)
 	if err != nil {
 		return nil, err
@@ -191,6 +221,8 @@ func (r *Repository) List(path string) ([]*ListEntry, error) {
 }
 
 func (r *Repository) Get(path string, hasRevision bool, revision Revision) ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	parsed, err := parseFilename(path)
 	if err != nil {
 		return nil, err
@@ -202,18 +234,20 @@ func (r *Repository) Get(path string, hasRevision bool, revision Revision) ([]by
 	}
 
 	if !hasRevision {
-		return file.revisions[len(file.revisions)-1], nil
+		revision = file.currentRevision()
 	}
 
 	if file.currentRevision() < revision || revision < 1 {
 		return nil, ErrNoRevision
 	}
 
-	return file.revisions[revision-1], nil
+	return r.storage.Read(file.path, revision)
 }
 
 func (r *Repository) Put(path string, data []byte) (Revision, error) {
 	fmt.Println("data", string(data))
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	parsed, err := parseFilename(path)
 	if err != nil {
 		return 0, err
@@ -228,8 +262,19 @@ func (r *Repository) Put(path string, data []byte) (Revision, error) {
 		return 0, ErrInvalidText
 	}
 
-	if len(file.revisions) == 0 || !bytes.Equal(data, file.revisions[len(file.revisions)-1]) {
-		file.revisions = append(file.revisions, data)
+	if current := file.currentRevision(); current > 0 {
+		last, err := r.storage.Read(file.path, current)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(data, last) {
+			return current, nil
+		}
+	}
+	revision, err := r.storage.Append(file.path, data)
+	if err != nil {
+		return 0, err
 	}
-	return file.currentRevision(), nil
+	file.revisions = revision
+	return revision, nil
 }
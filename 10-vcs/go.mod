@@ -1,3 +1,7 @@
 module z10f.com/golang/protohackers/10
 
 go 1.19
+
+require z10f.com/golang/protohackers/08 v0.0.0
+
+replace z10f.com/golang/protohackers/08 => ../08-isl
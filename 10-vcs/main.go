@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -10,6 +11,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"z10f.com/golang/protohackers/08/isl"
 )
 
 //const TIMEOUT_SECONDS = 5
@@ -128,10 +131,25 @@ func handleConnection(conn net.Conn, repo *Repository) {
 	}
 }
 
+var useISL = flag.Bool("isl", false, "serve over the Insecure Sockets Layer instead of plain TCP")
+
+var dataDir = flag.String("data", "", "directory to keep the repository in (default memory only)")
+
 func main() {
+	flag.Parse()
 	repository := NewRepository()
+	if *dataDir != "" {
+		storage, err := OpenDiskStorage(*dataDir)
+		if err != nil {
+			log.Fatal("could not open storage: ", err)
+		}
+		repository, err = OpenRepository(storage)
+		if err != nil {
+			log.Fatal("could not load repository: ", err)
+		}
+	}
 
-	l, err := net.Listen("tcp", ":1337")
+	l, err := isl.ListenIf(*useISL, "tcp", ":1337")
 	if err != nil {
 		log.Fatal("could not listen", err)
 	}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"testing"
+
+	"z10f.com/golang/protohackers/08/isl"
+)
+
+func TestISL(t *testing.T) {
+	l, err := isl.ListenIf(true, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	repository := NewRepository()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		handleConnection(conn, repository)
+	}()
+
+	spec, err := isl.ParseCipherSpec("xor(0x7b),addpos,reversebits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := isl.Dial("tcp", l.Addr().String(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	io.WriteString(conn, "PUT /a.txt 6\nhello\n")
+	io.WriteString(conn, "GET /a.txt\n")
+	for _, expected := range []string{"READY", "OK r1", "READY", "OK 6", "hello", "READY"} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading %q: %s", expected, err)
+		}
+		if line != expected+"\n" {
+			t.Fatalf("got %q, expected %q", line, expected)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+)
+
+// StoredFile is a file a Storage has revisions of.
+type StoredFile struct {
+	Path      []string
+	Revisions Revision
+}
+
+// Storage keeps the revisions of a Repository's files. Paths are the
+// components of a file's name, already checked by parseFilename, and
+// revisions count from 1.
+type Storage interface {
+	// Files lists every file with at least one revision.
+	Files() ([]StoredFile, error)
+	// Read returns a revision of a file, or ErrNoRevision.
+	Read(path []string, rev Revision) ([]byte, error)
+	// Append stores data as the next revision of a file, and returns its
+	// number.
+	Append(path []string, data []byte) (Revision, error)
+}
+
+// MemoryStorage keeps everything in memory, and forgets it all on restart.
+type MemoryStorage struct {
+	files map[string][][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		files: make(map[string][][]byte),
+	}
+}
+
+func (m *MemoryStorage) Files() ([]StoredFile, error) {
+	files := []StoredFile{}
+	for name, revisions := range m.files {
+		files = append(files, StoredFile{
+			Path:      strings.Split(name, "/"),
+			Revisions: Revision(len(revisions)),
+		})
+	}
+	return files, nil
+}
+
+func (m *MemoryStorage) Read(path []string, rev Revision) ([]byte, error) {
+	revisions := m.files[strings.Join(path, "/")]
+	if rev < 1 || int(rev) > len(revisions) {
+		return nil, ErrNoRevision
+	}
+	return revisions[rev-1], nil
+}
+
+func (m *MemoryStorage) Append(path []string, data []byte) (Revision, error) {
+	name := strings.Join(path, "/")
+	m.files[name] = append(m.files[name], data)
+	return Revision(len(m.files[name])), nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func storages(t *testing.T) map[string]Storage {
+	disk, err := OpenDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Storage{
+		"memory": NewMemoryStorage(),
+		"disk":   disk,
+	}
+}
+
+func TestStorage(t *testing.T) {
+	for name, s := range storages(t) {
+		t.Run(name, func(t *testing.T) {
+			a := []string{"dir", "a"}
+			for i, data := range []string{"one\n", "two\n", "one\n"} {
+				rev, err := s.Append(a, []byte(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rev != Revision(i+1) {
+					t.Errorf("got r%d, want r%d", rev, i+1)
+				}
+			}
+			got, err := s.Read(a, 2)
+			if err != nil || string(got) != "two\n" {
+				t.Errorf("got %q, %v", got, err)
+			}
+			for _, rev := range []Revision{0, 4} {
+				if _, err := s.Read(a, rev); !errors.Is(err, ErrNoRevision) {
+					t.Errorf("r%d: expected ErrNoRevision, got %v", rev, err)
+				}
+			}
+			if _, err := s.Read([]string{"nope"}, 1); !errors.Is(err, ErrNoRevision) {
+				t.Errorf("expected ErrNoRevision, got %v", err)
+			}
+
+			// a file and a directory can share a name, and odd names
+			// stay where they belong
+			s.Append([]string{"dir"}, []byte("file\n"))
+			s.Append([]string{"..", "."}, []byte("dots\n"))
+			files, err := s.Files()
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, f := range files {
+				names = append(names, fmt.Sprintf("%s:%d", strings.Join(f.Path, "/"), f.Revisions))
+			}
+			sort.Strings(names)
+			want := []string{"../.:1", "dir/a:3", "dir:1"}
+			if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+				t.Errorf("got files %v, want %v", names, want)
+			}
+		})
+	}
+}
+
+func TestDiskRepository(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *Repository {
+		t.Helper()
+		storage, err := OpenDiskStorage(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := OpenRepository(storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+	repo := open()
+	repo.Put("/a/b", []byte("first\n"))
+	repo.Put("/a/b", []byte("second\n"))
+	repo.Put("/a/b", []byte("second\n"))
+	repo.Put("/c", []byte("first\n"))
+
+	// everything is still there after a restart
+	repo = open()
+	list, err := repo.List("/a")
+	if err != nil || len(list) != 1 || list[0].Name != "b" || list[0].Revision != 2 {
+		t.Fatalf("got %v, %v", list, err)
+	}
+	got, err := repo.Get("/a/b", true, 1)
+	if err != nil || string(got) != "first\n" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if rev, err := repo.Put("/a/b", []byte("second\n")); rev != 2 || err != nil {
+		t.Errorf("unchanged put gave r%d, %v", rev, err)
+	}
+	// identical contents are only stored once
+	blobs, _ := filepath.Glob(filepath.Join(dir, BLOB_DIR, "*", "*"))
+	if len(blobs) != 2 {
+		t.Errorf("expected 2 blobs, got %d", len(blobs))
+	}
+}
+
+func TestDiskRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := []string{"f"}
+	for _, data := range []string{"one\n", "two\n", "three\n"} {
+		if _, err := s.Append(path, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a crash in the middle of a write, and a blob lost along the way
+	tmp := filepath.Join(dir, TREE_DIR, "12345.tmp")
+	if err := os.WriteFile(tmp, []byte("half"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(s.blobPath(s.files["f"][1])); err != nil {
+		t.Fatal(err)
+	}
+	// and an append cut short
+	f, err := os.OpenFile(s.indexPath(path), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("0123")
+	f.Close()
+
+	s, err = OpenDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+	repo, err := OpenRepository(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only what can still be read is kept
+	list, _ := repo.List("/")
+	if len(list) != 1 || list[0].Revision != 1 {
+		t.Fatalf("got %+v", list)
+	}
+	rev, err := repo.Put("/f", []byte("again\n"))
+	if err != nil || rev != 2 {
+		t.Errorf("got r%d, %v", rev, err)
+	}
+	got, _ := repo.Get("/f", false, 0)
+	if !bytes.Equal(got, []byte("again\n")) {
+		t.Errorf("got %q", got)
+	}
+	// the put went on a line of its own
+	s, err = OpenDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.files["f"]) != 2 {
+		t.Errorf("after reopening, got %d revisions", len(s.files["f"]))
+	}
+}
+
+func TestDiskBlobError(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := []string{"f"}
+	for _, data := range []string{"one\n", "two\n", "three\n"} {
+		if _, err := s.Append(path, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index, err := os.ReadFile(s.indexPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second blob can't be checked, though it isn't known to be gone
+	blobDir := filepath.Dir(s.blobPath(s.files["f"][1]))
+	moved := blobDir + ".away"
+	if err := os.Rename(blobDir, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blobDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenDiskStorage(dir); err == nil {
+		t.Error("expected an error")
+	}
+	if after, _ := os.ReadFile(s.indexPath(path)); !bytes.Equal(after, index) {
+		t.Errorf("index changed to %q", after)
+	}
+
+	// once the trouble passes, nothing has been lost
+	if err := os.Remove(blobDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(moved, blobDir); err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.files["f"]) != 3 {
+		t.Errorf("got %d revisions", len(s.files["f"]))
+	}
+}